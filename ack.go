@@ -0,0 +1,133 @@
+package job
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/navi-tt/job/internal/log"
+	"github.com/navi-tt/job/internal/queue"
+)
+
+// 批量ack聚合器, 按数量或时间间隔刷新
+// 任务处理协程只需要把任务交给聚合器即可释放协程池的位置
+type ackBatcher struct {
+	w        *WorkerWithFunc
+	q        queue.BatchAckQueue
+	size     int
+	interval time.Duration
+
+	ch   chan *Task
+	quit chan struct{}
+
+	// 提交任务时持有读锁, 关闭时持有写锁, 保证关闭后run的最后一次刷新能读到所有已提交的任务
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAckBatcher(w *WorkerWithFunc, q queue.BatchAckQueue, size int, interval time.Duration) *ackBatcher {
+	return &ackBatcher{
+		w:        w,
+		q:        q,
+		size:     size,
+		interval: interval,
+		ch:       make(chan *Task, size),
+		quit:     make(chan struct{}),
+	}
+}
+
+// 提交待ack的任务, 聚合器已关闭时同步ack
+func (b *ackBatcher) add(task *Task) {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		b.w.ack(task)
+		return
+	}
+	b.w.Job().wg.Add(1)
+	b.ch <- task
+	b.mu.RUnlock()
+}
+
+func (b *ackBatcher) run() {
+	buf := make([]*Task, 0, b.size)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case task := <-b.ch:
+			buf = append(buf, task)
+			if len(buf) >= b.size {
+				buf = b.flush(buf)
+			}
+		case <-ticker.C:
+			buf = b.flush(buf)
+		case <-b.quit:
+			// 把已经提交的任务全部刷新后退出
+			for {
+				select {
+				case task := <-b.ch:
+					buf = append(buf, task)
+				default:
+					b.flush(buf)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *ackBatcher) flush(buf []*Task) []*Task {
+	if len(buf) == 0 {
+		return buf
+	}
+	defer b.w.Job().wg.Add(-len(buf))
+
+	tokens := make([]string, len(buf))
+	for k, task := range buf {
+		tokens[k] = task.Token
+	}
+	_, err := b.q.BatchAckMsg(b.w.Job().ctx, b.w.Topic(), tokens, b.w.Extra())
+	if err != nil {
+		for _, task := range buf {
+			b.w.ackFailed(task, err)
+		}
+	} else {
+		atomic.AddInt64(&b.w.Job().ackCount, int64(len(buf)))
+		for _, task := range buf {
+			b.w.releaseClaim(task)
+		}
+	}
+	return buf[:0]
+}
+
+// 等待正在提交的任务放入ch后再通知run退出, 之后提交的任务同步ack
+func (b *ackBatcher) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.quit)
+	}
+}
+
+// 同步ack单个任务, 成功返回true
+func (w *WorkerWithFunc) ack(task *Task) bool {
+	_, err := w.Queue().AckMsg(w.Job().ctx, w.Topic(), task.Token, w.Extra())
+	if err != nil {
+		w.ackFailed(task, err)
+		return false
+	}
+	atomic.AddInt64(&w.Job().ackCount, 1)
+	w.releaseClaim(task)
+	return true
+}
+
+func (w *WorkerWithFunc) ackFailed(task *Task, err error) {
+	atomic.AddInt64(&w.Job().ackErrCount, 1)
+	if w.Job().ackErrCallback != nil {
+		w.Job().ackErrCallback(task, err)
+	} else {
+		log.Error("ack_error", w.Topic(), task, err)
+	}
+}
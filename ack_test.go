@@ -0,0 +1,93 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 支持批量ack的队列
+type batchAckQueue struct {
+	*memQueue
+	batches int64
+}
+
+func (q *batchAckQueue) BatchAckMsg(ctx context.Context, key string, tokens []string, args ...interface{}) (bool, error) {
+	atomic.AddInt64(&q.batches, 1)
+	atomic.AddInt64(&q.acks, int64(len(tokens)))
+	return true, nil
+}
+
+func TestAckBatch(t *testing.T) {
+	q := &batchAckQueue{memQueue: newMemQueue()}
+	j := New()
+	j.SetAckBatch(10, time.Hour)
+	j.AddFunc(q, "a", func(ctx context.Context, task *Task) {}, 4)
+	msgs := make([]string, 100)
+	for i := range msgs {
+		msgs[i] = "m"
+	}
+	j.BatchEnqueue(context.Background(), "a", msgs)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&q.acks) == 100 })
+	if b := atomic.LoadInt64(&q.batches); b != 10 {
+		t.Fatalf("batches %d", b)
+	}
+	if s := j.Stats(); s["ack"] != 100 {
+		t.Fatal(s)
+	}
+}
+
+// 关闭聚合器的同时还有任务在提交, 所有任务都要ack且WaitGroup计数归零
+func TestAckBatcherCloseWhileAdding(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		q := &batchAckQueue{memQueue: newMemQueue()}
+		j := New()
+		j.AddFunc(q, "a", func(ctx context.Context, task *Task) {}, 1)
+		w, _ := j.getWorker("a")
+		b := newAckBatcher(w, q, 4, time.Hour)
+		exited := make(chan struct{})
+		go func() {
+			b.run()
+			close(exited)
+		}()
+
+		const n = 50
+		var wg sync.WaitGroup
+		add := func() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.add(&Task{Token: "tok"})
+			}()
+		}
+		for k := 0; k < n/2; k++ {
+			add()
+		}
+		b.close()
+		<-exited
+		// 聚合器退出后才完成的任务
+		for k := n / 2; k < n; k++ {
+			add()
+		}
+		wg.Wait()
+
+		done := make(chan struct{})
+		go func() {
+			j.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("wait group not released")
+		}
+		if acks := atomic.LoadInt64(&q.acks); acks != n {
+			t.Fatalf("acked %d of %d", acks, n)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package job
+
+import (
+	"sync"
+	"time"
+)
+
+// 自适应并发控制, 根据任务耗时和失败情况调整worker协程池的有效大小
+type ConcurrencyLimiter interface {
+	// 任务处理完成后上报耗时和是否失败(StateFailed/StateFailedWithAck/panic)
+	Observe(latency time.Duration, failed bool)
+	// 当前允许的并发数
+	Limit() int
+}
+
+// AIMD(加性增乘性减): 每个窗口统计一次, 平均耗时超过latency或失败率超过errRate时
+// 并发数乘以0.5, 否则加1, 并发数在[min, max]之间
+type aimdLimiter struct {
+	mu      sync.Mutex
+	min     int
+	max     int
+	limit   int
+	latency time.Duration
+	errRate float64
+	window  time.Duration
+
+	start    time.Time
+	count    int64
+	errCount int64
+	total    time.Duration
+}
+
+func NewAIMDLimiter(min, max int, latency time.Duration, errRate float64) ConcurrencyLimiter {
+	if min <= 0 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &aimdLimiter{
+		min:     min,
+		max:     max,
+		limit:   max,
+		latency: latency,
+		errRate: errRate,
+		window:  time.Second,
+		start:   time.Now(),
+	}
+}
+
+func (l *aimdLimiter) Observe(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count++
+	l.total += latency
+	if failed {
+		l.errCount++
+	}
+	if time.Since(l.start) < l.window {
+		return
+	}
+
+	avg := l.total / time.Duration(l.count)
+	rate := float64(l.errCount) / float64(l.count)
+	if (l.latency > 0 && avg > l.latency) || (l.errRate > 0 && rate > l.errRate) {
+		l.limit = l.limit / 2
+		if l.limit < l.min {
+			l.limit = l.min
+		}
+	} else if l.limit < l.max {
+		l.limit++
+	}
+
+	l.start = time.Now()
+	l.count, l.errCount, l.total = 0, 0, 0
+}
+
+func (l *aimdLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+type concurrencyLimiterBox struct {
+	ConcurrencyLimiter
+}
+
+// 设置worker的自适应并发控制, 传nil取消, 取消后协程池大小保持当前值
+// 开启后SetConcurrency设置的协程池大小会被自适应并发控制覆盖
+func (w *WorkerWithFunc) SetConcurrencyLimiter(c ConcurrencyLimiter) {
+	w.concurrencyLimiter.Store(concurrencyLimiterBox{c})
+	if c != nil {
+		w.Tune(c.Limit())
+	}
+}
+
+// 上报任务处理结果, 并发数变化时调整协程池大小
+func (w *WorkerWithFunc) observe(latency time.Duration, failed bool) {
+	box, _ := w.concurrencyLimiter.Load().(concurrencyLimiterBox)
+	if box.ConcurrencyLimiter == nil {
+		return
+	}
+	box.Observe(latency, failed)
+	if limit := box.Limit(); limit != w.Cap() {
+		w.Tune(limit)
+	}
+}
+
+func taskFailed(task *Task) bool {
+	return task.Result.State == StateFailed || task.Result.State == StateFailedWithAck
+}
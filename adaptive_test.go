@@ -0,0 +1,75 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAIMDLimiter(t *testing.T) {
+	l := NewAIMDLimiter(2, 8, 10*time.Millisecond, 0.5).(*aimdLimiter)
+	// 每次上报都作为一个窗口
+	l.window = 0
+	if l.Limit() != 8 {
+		t.Fatalf("initial limit %d", l.Limit())
+	}
+
+	// 超时乘性减, 不低于min
+	for _, want := range []int{4, 2, 2} {
+		l.Observe(50*time.Millisecond, false)
+		if got := l.Limit(); got != want {
+			t.Fatalf("after slow task limit %d, want %d", got, want)
+		}
+	}
+	// 正常加性增, 不超过max
+	for i := 0; i < 10; i++ {
+		l.Observe(time.Millisecond, false)
+	}
+	if got := l.Limit(); got != 8 {
+		t.Fatalf("recovered limit %d", got)
+	}
+	// 失败率超过阈值
+	l.Observe(time.Millisecond, true)
+	if got := l.Limit(); got != 4 {
+		t.Fatalf("after failure limit %d", got)
+	}
+}
+
+// 窗口内按平均值判断
+func TestAIMDLimiterWindow(t *testing.T) {
+	l := NewAIMDLimiter(1, 10, 10*time.Millisecond, 0).(*aimdLimiter)
+	l.window = 20 * time.Millisecond
+	l.Observe(100*time.Millisecond, false)
+	if got := l.Limit(); got != 10 {
+		t.Fatalf("adjusted before window end: %d", got)
+	}
+	time.Sleep(25 * time.Millisecond)
+	l.Observe(time.Millisecond, false)
+	if got := l.Limit(); got != 5 {
+		t.Fatalf("limit %d", got)
+	}
+}
+
+func TestSetConcurrencyLimiter(t *testing.T) {
+	j := New()
+	j.AddFunc(newMemQueue(), "a", func(ctx context.Context, task *Task) {}, 10)
+	l := NewAIMDLimiter(1, 3, time.Millisecond, 0).(*aimdLimiter)
+	l.window = 0
+	if err := j.SetConcurrencyLimiter("a", l); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := j.getWorker("a")
+	if w.Concurrency() != 3 {
+		t.Fatalf("concurrency %d", w.Concurrency())
+	}
+	w.observe(time.Second, false)
+	if w.Concurrency() != 1 {
+		t.Fatalf("concurrency %d", w.Concurrency())
+	}
+	// 取消后保持当前值
+	j.SetConcurrencyLimiter("a", nil)
+	w.observe(time.Second, false)
+	if w.Concurrency() != 1 {
+		t.Fatalf("concurrency %d", w.Concurrency())
+	}
+}
@@ -0,0 +1,268 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBufferFull     = errors.New("producer buffer is full")
+	ErrProducerClosed = errors.New("producer is closed")
+)
+
+type OverflowPolicy int
+
+const (
+	//缓冲满时阻塞等待，直到有空间或者ctx取消
+	OverflowBlock OverflowPolicy = iota
+	//缓冲满时直接丢弃，返回ErrBufferFull
+	OverflowDrop
+)
+
+// 异步生产者配置, 零值字段使用默认值
+type AsyncProducerConfig struct {
+	BatchSize   int            // 每个topic累计多少条消息提交一次, 默认100
+	Linger      time.Duration  // 第一条消息最多等待多久提交, 默认100毫秒
+	MaxBuffered int            // 所有topic合计最多缓冲的消息数, 默认10000
+	Overflow    OverflowPolicy // 缓冲满时的处理策略, 默认阻塞
+}
+
+// 入队结果, 消息提交到queue驱动后完成
+type Future struct {
+	task   Task
+	result EnqueueResult
+	done   chan struct{}
+}
+
+func newFuture(task Task) *Future {
+	return &Future{task: task, done: make(chan struct{})}
+}
+
+func (f *Future) complete(r EnqueueResult) {
+	f.result = r
+	close(f.done)
+}
+
+// 完成时关闭的通道
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// 阻塞等待入队完成, 返回入队错误
+func (f *Future) Wait() error {
+	<-f.done
+	return f.result.Err
+}
+
+// 阻塞等待入队完成, 返回任务id和队列服务生成的消息id
+func (f *Future) Result() EnqueueResult {
+	<-f.done
+	return f.result
+}
+
+// 入队的任务
+func (f *Future) Task() Task {
+	return f.task
+}
+
+// 异步生产者: 按topic缓冲消息, 达到数量或者等待时间后通过BatchEnqueue批量提交
+type AsyncProducer struct {
+	p    *Producer
+	cfg  AsyncProducerConfig
+	args []interface{}
+
+	mu       sync.Mutex
+	buffers  map[string]*topicBuffer
+	closed   bool
+	callback func(task *Task, err error)
+
+	slots   chan struct{} // 缓冲名额
+	sending int           // 正在提交的批次数
+	sent    *sync.Cond    // 提交完成通知
+}
+
+type topicBuffer struct {
+	futures []*Future
+	timer   *time.Timer
+}
+
+// args为提交时透传给queue驱动的额外参数
+func NewAsyncProducer(p *Producer, cfg AsyncProducerConfig, args ...interface{}) *AsyncProducer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = defaultLinger
+	}
+	if cfg.MaxBuffered <= 0 {
+		cfg.MaxBuffered = 10000
+	}
+	a := &AsyncProducer{
+		p:       p,
+		cfg:     cfg,
+		args:    args,
+		buffers: make(map[string]*topicBuffer),
+		slots:   make(chan struct{}, cfg.MaxBuffered),
+	}
+	a.sent = sync.NewCond(&a.mu)
+	return a
+}
+
+// 设置入队结果回调函数, 每条消息完成时调用一次
+func (a *AsyncProducer) SetCallback(f func(task *Task, err error)) {
+	a.mu.Lock()
+	a.callback = f
+	a.mu.Unlock()
+}
+
+//消息入队 -- 原始message
+func (a *AsyncProducer) Enqueue(ctx context.Context, topic string, message string) *Future {
+	return a.EnqueueWithTask(ctx, topic, GenTask(topic, message))
+}
+
+//消息入队 -- Task数据结构, ctx只用于缓冲满时的阻塞等待
+func (a *AsyncProducer) EnqueueWithTask(ctx context.Context, topic string, task Task) *Future {
+	if task.Topic == "" {
+		task.Topic = topic
+	}
+	f := newFuture(task)
+
+	switch a.cfg.Overflow {
+	case OverflowDrop:
+		select {
+		case a.slots <- struct{}{}:
+		default:
+			a.finish([]*Future{f}, ErrBufferFull)
+			return f
+		}
+	default:
+		select {
+		case a.slots <- struct{}{}:
+		case <-ctx.Done():
+			a.finish([]*Future{f}, ctx.Err())
+			return f
+		}
+	}
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		<-a.slots
+		a.finish([]*Future{f}, ErrProducerClosed)
+		return f
+	}
+	b, ok := a.buffers[topic]
+	if !ok {
+		b = new(topicBuffer)
+		a.buffers[topic] = b
+	}
+	b.futures = append(b.futures, f)
+	if len(b.futures) >= a.cfg.BatchSize {
+		futures := a.take(topic)
+		a.sending++
+		a.mu.Unlock()
+		go a.send(topic, futures)
+		return f
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(a.cfg.Linger, func() { a.flushTopic(topic) })
+	}
+	a.mu.Unlock()
+	return f
+}
+
+// 取出topic缓冲的消息, 调用方需持有锁
+func (a *AsyncProducer) take(topic string) []*Future {
+	b, ok := a.buffers[topic]
+	if !ok {
+		return nil
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	delete(a.buffers, topic)
+	return b.futures
+}
+
+func (a *AsyncProducer) flushTopic(topic string) {
+	a.mu.Lock()
+	futures := a.take(topic)
+	if len(futures) > 0 {
+		a.sending++
+	}
+	a.mu.Unlock()
+	if len(futures) > 0 {
+		a.send(topic, futures)
+	}
+}
+
+// 提交一批消息, 调用前需要在持有锁时增加sending
+func (a *AsyncProducer) send(topic string, futures []*Future) {
+	defer func() {
+		a.mu.Lock()
+		a.sending--
+		if a.sending == 0 {
+			a.sent.Broadcast()
+		}
+		a.mu.Unlock()
+	}()
+	tasks := make([]Task, len(futures))
+	for k, f := range futures {
+		tasks[k] = f.task
+	}
+	results, _ := a.p.BatchEnqueueWithResult(context.Background(), topic, tasks, a.args...)
+	for range futures {
+		<-a.slots
+	}
+	a.complete(futures, results)
+}
+
+func (a *AsyncProducer) finish(futures []*Future, err error) {
+	results := make([]EnqueueResult, len(futures))
+	for k, f := range futures {
+		results[k] = EnqueueResult{Id: f.task.Id, Err: err}
+	}
+	a.complete(futures, results)
+}
+
+func (a *AsyncProducer) complete(futures []*Future, results []EnqueueResult) {
+	a.mu.Lock()
+	callback := a.callback
+	a.mu.Unlock()
+	for k, f := range futures {
+		f.complete(results[k])
+		if callback != nil {
+			callback(&f.task, results[k].Err)
+		}
+	}
+}
+
+// 立即提交所有缓冲的消息, 并等待提交完成
+func (a *AsyncProducer) Flush() {
+	a.mu.Lock()
+	topics := make([]string, 0, len(a.buffers))
+	for topic := range a.buffers {
+		topics = append(topics, topic)
+	}
+	a.mu.Unlock()
+
+	for _, topic := range topics {
+		a.flushTopic(topic)
+	}
+
+	a.mu.Lock()
+	for a.sending > 0 {
+		a.sent.Wait()
+	}
+	a.mu.Unlock()
+}
+
+// 关闭生产者, 提交所有缓冲的消息, 关闭后入队返回ErrProducerClosed
+func (a *AsyncProducer) Close() {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	a.Flush()
+}
@@ -0,0 +1,123 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 记录批量入队的次数, gate不为nil时阻塞到gate关闭
+type gateQueue struct {
+	*memQueue
+	batches int64
+	gate    chan struct{}
+}
+
+func (q *gateQueue) BatchEnqueue(ctx context.Context, key string, messages []string, args ...interface{}) (bool, error) {
+	atomic.AddInt64(&q.batches, 1)
+	if q.gate != nil {
+		<-q.gate
+	}
+	return q.memQueue.BatchEnqueue(ctx, key, messages, args...)
+}
+
+func TestAsyncProducerBatch(t *testing.T) {
+	q := &gateQueue{memQueue: newMemQueue()}
+	p := NewProducer()
+	p.AddQueue(q)
+	a := NewAsyncProducer(p, AsyncProducerConfig{BatchSize: 10, Linger: time.Hour})
+	var mu sync.Mutex
+	var callbacks int
+	a.SetCallback(func(task *Task, err error) {
+		mu.Lock()
+		callbacks++
+		mu.Unlock()
+	})
+
+	ctx := context.Background()
+	futures := make([]*Future, 25)
+	for i := range futures {
+		futures[i] = a.Enqueue(ctx, "t", "m")
+	}
+	// 前两批达到BatchSize立即提交
+	for _, f := range futures[:20] {
+		if err := f.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-futures[20].Done():
+		t.Fatal("partial batch sent before linger")
+	default:
+	}
+
+	a.Close()
+	for _, f := range futures {
+		r := f.Result()
+		if r.Err != nil || r.Id != f.Task().Id {
+			t.Fatalf("result %+v", r)
+		}
+	}
+	if q.len("t") != 25 || atomic.LoadInt64(&q.batches) != 3 {
+		t.Fatalf("enqueued %d in %d batches", q.len("t"), q.batches)
+	}
+	mu.Lock()
+	n := callbacks
+	mu.Unlock()
+	if n != 25 {
+		t.Fatalf("callbacks %d", n)
+	}
+
+	if err := a.Enqueue(ctx, "t", "m").Wait(); err != ErrProducerClosed {
+		t.Fatal(err)
+	}
+}
+
+func TestAsyncProducerLinger(t *testing.T) {
+	q := newMemQueue()
+	p := NewProducer()
+	p.AddQueue(q)
+	a := NewAsyncProducer(p, AsyncProducerConfig{BatchSize: 100, Linger: 20 * time.Millisecond})
+	defer a.Close()
+	f := a.Enqueue(context.Background(), "t", "m")
+	select {
+	case <-f.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("not sent after linger")
+	}
+	if q.len("t") != 1 {
+		t.Fatal("not enqueued")
+	}
+}
+
+func TestAsyncProducerOverflow(t *testing.T) {
+	q := &gateQueue{memQueue: newMemQueue(), gate: make(chan struct{})}
+	p := NewProducer()
+	p.AddQueue(q)
+	a := NewAsyncProducer(p, AsyncProducerConfig{BatchSize: 2, MaxBuffered: 2, Overflow: OverflowDrop})
+	ctx := context.Background()
+	a.Enqueue(ctx, "t", "a")
+	a.Enqueue(ctx, "t", "b")
+	// 提交被阻塞, 缓冲名额没有释放
+	if err := a.Enqueue(ctx, "t", "c").Wait(); err != ErrBufferFull {
+		t.Fatal(err)
+	}
+
+	// 阻塞策略等待到ctx超时
+	b := NewAsyncProducer(p, AsyncProducerConfig{BatchSize: 1, MaxBuffered: 1})
+	b.Enqueue(ctx, "t", "a")
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := b.Enqueue(timeout, "t", "b").Wait(); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	close(q.gate)
+	a.Close()
+	b.Close()
+	if q.len("t") != 3 {
+		t.Fatalf("enqueued %d", q.len("t"))
+	}
+}
@@ -0,0 +1,127 @@
+package job
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 退避策略：拉取为空或异常时，worker需要等待的时间
+// 每个worker持有自己的实例，互不影响
+type Backoff interface {
+	// 返回下一次需要等待的时间
+	Next() time.Duration
+	// 拉取到数据后重置
+	Reset()
+}
+
+// 退避策略构造函数，每个worker启动时调用一次生成自己的退避实例
+type NewBackoffFunc func() Backoff
+
+// 指数退避：从init开始，每次翻倍，直到max
+type exponentialBackoff struct {
+	mu   sync.Mutex
+	init time.Duration
+	max  time.Duration
+	cur  time.Duration
+}
+
+func NewExponentialBackoff(init, max time.Duration) Backoff {
+	if max < init {
+		max = init
+	}
+	return &exponentialBackoff{init: init, max: max}
+}
+
+func (b *exponentialBackoff) Next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cur <= 0 {
+		b.cur = b.init
+	} else if b.cur*2 < b.max {
+		b.cur = b.cur * 2
+	} else {
+		b.cur = b.max
+	}
+	return b.cur
+}
+
+func (b *exponentialBackoff) Reset() {
+	b.mu.Lock()
+	b.cur = 0
+	b.mu.Unlock()
+}
+
+// 去相关抖动退避：sleep = min(max, random(base, sleep*3))
+// 多个实例同时退避时可以把请求打散，避免同时打到队列服务
+type decorrelatedJitterBackoff struct {
+	mu   sync.Mutex
+	base time.Duration
+	max  time.Duration
+	cur  time.Duration
+	rnd  *rand.Rand // 每个实例独立的随机数, 不同进程和worker的序列不同
+}
+
+func NewDecorrelatedJitterBackoff(base, max time.Duration) Backoff {
+	if max < base {
+		max = base
+	}
+	return &decorrelatedJitterBackoff{base: base, max: max, cur: base, rnd: rand.New(rand.NewSource(randomSeed()))}
+}
+
+// 随机种子, 全局math/rand在go1.20之前默认种子固定, 所有副本的抖动序列相同
+func randomSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+func (b *decorrelatedJitterBackoff) Next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	upper := b.cur * 3
+	if upper <= b.base {
+		b.cur = b.base
+	} else {
+		b.cur = b.base + time.Duration(b.rnd.Int63n(int64(upper-b.base)))
+	}
+	if b.cur > b.max {
+		b.cur = b.max
+	}
+	return b.cur
+}
+
+func (b *decorrelatedJitterBackoff) Reset() {
+	b.mu.Lock()
+	b.cur = b.base
+	b.mu.Unlock()
+}
+
+// 固定时间退避
+type constantBackoff time.Duration
+
+func NewConstantBackoff(d time.Duration) Backoff {
+	return constantBackoff(d)
+}
+
+func (b constantBackoff) Next() time.Duration {
+	return time.Duration(b)
+}
+
+func (b constantBackoff) Reset() {}
+
+func ExponentialBackoff(init, max time.Duration) NewBackoffFunc {
+	return func() Backoff { return NewExponentialBackoff(init, max) }
+}
+
+func DecorrelatedJitterBackoff(base, max time.Duration) NewBackoffFunc {
+	return func() Backoff { return NewDecorrelatedJitterBackoff(base, max) }
+}
+
+func ConstantBackoff(d time.Duration) NewBackoffFunc {
+	return func() Backoff { return NewConstantBackoff(d) }
+}
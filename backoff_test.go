@@ -0,0 +1,109 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := NewExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if got := b.Next(); got != w*time.Millisecond {
+			t.Fatalf("step %d: got %v, want %v", i, got, w*time.Millisecond)
+		}
+	}
+	b.Reset()
+	if got := b.Next(); got != 10*time.Millisecond {
+		t.Fatalf("after reset: got %v", got)
+	}
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	base, max := time.Millisecond, time.Second
+	a := NewDecorrelatedJitterBackoff(base, max)
+	b := NewDecorrelatedJitterBackoff(base, max)
+	same := true
+	for i := 0; i < 20; i++ {
+		x, y := a.Next(), b.Next()
+		if x < base || x > max || y < base || y > max {
+			t.Fatalf("out of range: %v %v", x, y)
+		}
+		same = same && x == y
+	}
+	if same {
+		t.Fatal("two instances produced the same jitter sequence")
+	}
+}
+
+func TestConstantBackoff(t *testing.T) {
+	b := NewConstantBackoff(time.Second)
+	b.Next()
+	if b.Next() != time.Second {
+		t.Fatal("constant backoff changed")
+	}
+}
+
+// 每个worker使用自己的退避实例, 空队列的worker退避到上限后不影响有数据的worker
+func TestPerWorkerBackoff(t *testing.T) {
+	const max = time.Second
+	var mu sync.Mutex
+	var instances []Backoff
+	q := newMemQueue()
+	j := New()
+	j.SetEmptyBackoffFunc(func() Backoff {
+		b := NewExponentialBackoff(time.Millisecond, max)
+		mu.Lock()
+		instances = append(instances, b)
+		mu.Unlock()
+		return b
+	})
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(instances)
+	}
+	done := make(chan struct{}, 1)
+	j.AddFunc(q, "idle", func(ctx context.Context, task *Task) {}, 1)
+	j.Start()
+	defer stopJob(t, j)
+	waitFor(t, time.Second, func() bool { return count() == 1 })
+	// 启动后添加的worker立即启动, 第二个实例属于busy
+	j.AddFunc(q, "busy", func(ctx context.Context, task *Task) { done <- struct{}{} }, 1)
+	waitFor(t, time.Second, func() bool { return count() == 2 })
+
+	mu.Lock()
+	idle, busy := instances[0], instances[1]
+	mu.Unlock()
+	if idle == busy {
+		t.Fatal("workers share a backoff instance")
+	}
+	for idle.Next() < max {
+	}
+
+	start := time.Now()
+	j.Enqueue(context.Background(), "busy", "m")
+	select {
+	case <-done:
+		if d := time.Since(start); d > max/4 {
+			t.Fatalf("busy worker picked up the task after %v", d)
+		}
+	case <-time.After(max / 2):
+		t.Fatal("busy worker slept with the idle worker's backoff")
+	}
+}
+
+func TestDeprecatedSleep(t *testing.T) {
+	j := New()
+	j.SetSleepy(time.Millisecond, 4*time.Millisecond)
+	start := time.Now()
+	j.Sleep()
+	j.Sleep()
+	j.ResetSleep()
+	j.Sleep()
+	if d := time.Since(start); d < 4*time.Millisecond {
+		t.Fatalf("slept %v", d)
+	}
+}
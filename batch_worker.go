@@ -0,0 +1,172 @@
+package job
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/navi-tt/job/internal/log"
+	"github.com/navi-tt/job/internal/queue"
+)
+
+const (
+	//默认每批任务数
+	defaultBatchSize = 100
+	//默认凑批等待时间
+	defaultLinger = time.Millisecond * 100
+)
+
+// 批量任务执行器, 处理完成后需要给每个任务设置Result
+// 只有StateFailed的任务不会ack, 其他任务正常ack, 互不影响
+type BatchWorker interface {
+	ExecBatch(context.Context, []*Task)
+}
+
+type BatchWorkerFunc func(context.Context, []*Task)
+
+func (f BatchWorkerFunc) ExecBatch(ctx context.Context, tasks []*Task) {
+	f(ctx, tasks)
+}
+
+// size为同时处理的批次数, 每批最多batchSize个任务, 凑不满时最多等待linger
+func (j *Job) NewBatchWorkerWithFunc(q queue.Queue, topic string, f func(context.Context, []*Task), size int, batchSize int, linger time.Duration, extra ...interface{}) (*WorkerWithFunc, error) {
+	err := validate(q, topic, f != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if size <= 0 {
+		size = defaultConcurrency
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	if linger <= 0 {
+		linger = defaultLinger
+	}
+
+	w, err := j.newWorker(q, topic, size, batchPipeSize(size, batchSize), extra)
+	if err != nil {
+		return nil, err
+	}
+	w.batchWorker = BatchWorkerFunc(f)
+	w.batchSize = batchSize
+	w.linger = linger
+
+	return w, nil
+}
+
+// pipe至少能容纳一整批任务, 批量出队时可以一次凑满
+func batchPipeSize(size int, batchSize int) int {
+	if size < batchSize {
+		return batchSize
+	}
+	return size
+}
+
+func (w *WorkerWithFunc) BatchWorker() BatchWorker {
+	return w.batchWorker
+}
+
+// 从pipe凑批, 数量达到batchSize或者第一个任务等待超过linger时提交到协程池
+func (w *WorkerWithFunc) runBatch() {
+	buf := make([]*Task, 0, w.batchSize)
+	var linger <-chan time.Time
+
+	submit := func() {
+		if len(buf) == 0 {
+			return
+		}
+		tasks := buf
+		buf = make([]*Task, 0, w.batchSize)
+		linger = nil
+		// 批次中的分区key还有批次在执行时等待其完成
+		keys := w.partitions.lockBatch(tasks)
+		// 协程池执行任务, 协程池可用协程为空则阻塞在此处
+		if err := w.Submit(func() {
+			defer w.partitions.unlockBatch(keys)
+			w.processBatch(tasks)
+		}); err != nil {
+			w.partitions.unlockBatch(keys)
+			atomic.AddInt64(&w.inflight, -int64(len(tasks)))
+			log.Error(err)
+		}
+	}
+
+	pipe := w.getPipe()
+	for w.Job().IsRunning() && w.isWorking() {
+		select {
+		case task, ok := <-pipe:
+			if !ok {
+				// pipe被调整大小, 旧pipe中的任务已经读完, 切换到替换它的pipe
+				if np := w.nextPipe(pipe); np != nil {
+					pipe = np
+					continue
+				}
+				submit()
+				return // channel pipe关闭, 即工作任务被关闭, 结束该协程
+			}
+			if len(buf) == 0 {
+				linger = time.After(w.linger)
+			}
+			buf = append(buf, task)
+			if len(buf) >= w.batchSize {
+				submit()
+			}
+		case <-linger:
+			submit()
+		}
+	}
+	submit()
+}
+
+func (w *WorkerWithFunc) processBatch(tasks []*Task) {
+	if !w.breakerAllow(tasks...) {
+		atomic.AddInt64(&w.inflight, -int64(len(tasks)))
+		return
+	}
+	w.Job().wg.Add(1)
+	start := time.Now()
+	defer func() {
+		w.Job().wg.Done()
+		atomic.AddInt64(&w.inflight, -int64(len(tasks)))
+		//任务panic回调函数, 整批任务都不会ack
+		e := recover()
+		if e != nil {
+			for _, task := range tasks {
+				w.taskPanic(task, e)
+			}
+		}
+		latency := time.Since(start)
+		batchFailed := e != nil
+		for _, task := range tasks {
+			failed := e != nil || taskFailed(task)
+			w.observe(latency, failed)
+			batchFailed = batchFailed || failed
+		}
+		// 整批作为一次熔断统计
+		w.breakerDone(batchFailed)
+	}()
+
+	//取回存入ClaimStore的消息体, 失败的任务不执行
+	ready := make([]*Task, 0, len(tasks))
+	for _, task := range tasks {
+		if w.resolveClaim(task) {
+			ready = append(ready, task)
+		}
+	}
+
+	//任务处理前回调函数
+	if w.Job().taskBeforeCallback != nil {
+		for _, task := range ready {
+			w.Job().taskBeforeCallback(task)
+		}
+	}
+
+	if len(ready) > 0 {
+		w.batchWorker.ExecBatch(w.Job().ctx, ready)
+	}
+	for _, task := range tasks {
+		w.finishTask(task)
+	}
+}
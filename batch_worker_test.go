@@ -0,0 +1,71 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 每批不超过batchSize, 只有StateFailed的任务不ack
+func TestBatchWorker(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	var (
+		mu    sync.Mutex
+		sizes []int
+		seen  int64
+	)
+	j.AddBatchFunc(q, "b", func(ctx context.Context, tasks []*Task) {
+		mu.Lock()
+		sizes = append(sizes, len(tasks))
+		mu.Unlock()
+		for _, task := range tasks {
+			if task.Message == "fail" {
+				task.Result = Result{State: StateFailed}
+			}
+			atomic.AddInt64(&seen, 1)
+		}
+	}, 2, 10, 20*time.Millisecond)
+
+	msgs := make([]string, 25)
+	for i := range msgs {
+		msgs[i] = "m"
+	}
+	msgs[3], msgs[17] = "fail", "fail"
+	j.BatchEnqueue(context.Background(), "b", msgs)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&seen) == 25 })
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt64(&q.acks) == 23 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, n := range sizes {
+		if n < 1 || n > 10 {
+			t.Fatalf("batch sizes %v", sizes)
+		}
+	}
+}
+
+// 不足一批时等待linger后提交
+func TestBatchWorkerLinger(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	got := make(chan int, 1)
+	j.AddBatchFunc(q, "b", func(ctx context.Context, tasks []*Task) { got <- len(tasks) }, 1, 100, 50*time.Millisecond)
+	j.BatchEnqueue(context.Background(), "b", []string{"a", "b", "c"})
+	j.Start()
+	defer stopJob(t, j)
+
+	select {
+	case n := <-got:
+		if n != 3 {
+			t.Fatalf("batch size %d", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("partial batch not submitted")
+	}
+}
@@ -0,0 +1,258 @@
+package job
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type BreakerState int32
+
+const (
+	//关闭：正常执行任务
+	BreakerClosed BreakerState = iota
+	//打开：停止拉取任务，已拉取的任务不执行，延迟到半开后重新入队
+	BreakerOpen
+	//半开：只拉取和放行少量探测任务，全部成功则关闭，任一失败则重新打开
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// 熔断配置, 零值字段使用默认值
+type BreakerConfig struct {
+	FailureRatio   float64       // 窗口内失败率达到该值时打开, 默认0.5
+	MinRequests    int           // 窗口内至少处理多少任务才计算失败率, 默认20
+	Window         time.Duration // 统计窗口, 默认10秒
+	OpenTimeout    time.Duration // 打开后多久进入半开, 默认30秒
+	HalfOpenProbes int           // 半开时放行的探测任务数, 默认1
+}
+
+type circuitBreaker struct {
+	mu  sync.Mutex
+	cfg BreakerConfig
+
+	state    BreakerState
+	start    time.Time
+	count    int
+	fails    int
+	openedAt time.Time
+	probing  int
+	probeOK  int
+}
+
+func newCircuitBreaker(cfg BreakerConfig) *circuitBreaker {
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Second * 10
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = time.Second * 30
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	return &circuitBreaker{cfg: cfg, start: time.Now()}
+}
+
+// 状态切换, from == to 表示没有切换
+type breakerTransition struct {
+	from BreakerState
+	to   BreakerState
+}
+
+// 切换状态, 调用方需持有锁
+func (b *circuitBreaker) setState(to BreakerState) breakerTransition {
+	t := breakerTransition{from: b.state, to: to}
+	b.state = to
+	switch to {
+	case BreakerClosed:
+		b.start, b.count, b.fails = time.Now(), 0, 0
+	case BreakerOpen:
+		b.openedAt = time.Now()
+	case BreakerHalfOpen:
+		b.probing, b.probeOK = 0, 0
+	}
+	return t
+}
+
+// 打开超时后进入半开, 调用方需持有锁
+func (b *circuitBreaker) refresh() breakerTransition {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		return b.setState(BreakerHalfOpen)
+	}
+	return breakerTransition{from: b.state, to: b.state}
+}
+
+// 可以拉取的任务数, 小于0表示不限制
+// 半开时只拉取剩余的探测名额, inflight为已拉取还未处理完成的任务数, 这些任务也会占用探测名额
+func (b *circuitBreaker) pullable(inflight int) (int, breakerTransition) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.refresh()
+	switch b.state {
+	case BreakerOpen:
+		return 0, t
+	case BreakerHalfOpen:
+		used := b.probing
+		if inflight > used {
+			used = inflight
+		}
+		if used >= b.cfg.HalfOpenProbes {
+			return 0, t
+		}
+		return b.cfg.HalfOpenProbes - used, t
+	}
+	return -1, t
+}
+
+// 是否可以执行任务
+func (b *circuitBreaker) allow() (bool, breakerTransition) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.refresh()
+	switch b.state {
+	case BreakerOpen:
+		return false, t
+	case BreakerHalfOpen:
+		if b.probing >= b.cfg.HalfOpenProbes {
+			return false, t
+		}
+		b.probing++
+	}
+	return true, t
+}
+
+// 上报任务执行结果
+func (b *circuitBreaker) done(failed bool) breakerTransition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		if time.Since(b.start) >= b.cfg.Window {
+			b.start, b.count, b.fails = time.Now(), 0, 0
+		}
+		b.count++
+		if failed {
+			b.fails++
+		}
+		if b.count >= b.cfg.MinRequests && float64(b.fails)/float64(b.count) >= b.cfg.FailureRatio {
+			return b.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if failed {
+			return b.setState(BreakerOpen)
+		}
+		b.probeOK++
+		if b.probeOK >= b.cfg.HalfOpenProbes {
+			return b.setState(BreakerClosed)
+		}
+	}
+	return breakerTransition{from: b.state, to: b.state}
+}
+
+// 被拒绝的任务多久之后再执行, 打开时为剩余的打开时间, 其他状态为0
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		if d := b.cfg.OpenTimeout - time.Since(b.openedAt); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+type breakerBox struct {
+	*circuitBreaker
+}
+
+// 设置worker的熔断, 传nil取消
+func (w *WorkerWithFunc) SetCircuitBreaker(cfg *BreakerConfig) {
+	if cfg == nil {
+		w.breaker.Store(breakerBox{})
+		return
+	}
+	w.breaker.Store(breakerBox{newCircuitBreaker(*cfg)})
+}
+
+func (w *WorkerWithFunc) circuitBreaker() *circuitBreaker {
+	box, _ := w.breaker.Load().(breakerBox)
+	return box.circuitBreaker
+}
+
+// 获取熔断状态, 未设置熔断时为BreakerClosed
+func (w *WorkerWithFunc) BreakerState() BreakerState {
+	if b := w.circuitBreaker(); b != nil {
+		return b.State()
+	}
+	return BreakerClosed
+}
+
+func (w *WorkerWithFunc) breakerChanged(t breakerTransition) {
+	if t.from != t.to && w.Job().breakerCallback != nil {
+		w.Job().breakerCallback(w.Topic(), t.from, t.to)
+	}
+}
+
+// 本次可以拉取的任务数, 0表示不拉取, 小于0表示不限制
+func (w *WorkerWithFunc) breakerPullable() int {
+	b := w.circuitBreaker()
+	if b == nil {
+		return -1
+	}
+	n, t := b.pullable(int(atomic.LoadInt64(&w.inflight)))
+	w.breakerChanged(t)
+	return n
+}
+
+// 熔断打开时拒绝执行, 任务延迟到熔断进入半开后重新入队并ack当前消息
+// 重新入队失败的任务不ack, 如果queue服务支持, 会进行消息重放
+func (w *WorkerWithFunc) breakerAllow(tasks ...*Task) bool {
+	b := w.circuitBreaker()
+	if b == nil {
+		return true
+	}
+	ok, t := b.allow()
+	w.breakerChanged(t)
+	if !ok {
+		atomic.AddInt64(&w.breakerRejected, int64(len(tasks)))
+		delay := b.retryAfter()
+		if delay < w.Job().timer {
+			delay = w.Job().timer
+		}
+		notBefore := time.Now().Add(delay)
+		for _, task := range tasks {
+			task.Result = Result{State: StateFailed, Message: "circuit breaker open"}
+			w.requeue(task, notBefore)
+		}
+	}
+	return ok
+}
+
+func (w *WorkerWithFunc) breakerDone(failed bool) {
+	if b := w.circuitBreaker(); b != nil {
+		w.breakerChanged(b.done(failed))
+	}
+}
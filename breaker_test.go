@@ -0,0 +1,143 @@
+package job
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{
+		FailureRatio:   0.5,
+		MinRequests:    4,
+		Window:         time.Hour,
+		OpenTimeout:    30 * time.Millisecond,
+		HalfOpenProbes: 2,
+	})
+
+	// 没达到MinRequests不打开
+	for i := 0; i < 3; i++ {
+		b.allow()
+		b.done(true)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatal(b.State())
+	}
+	b.allow()
+	if tr := b.done(false); tr.from != BreakerClosed || tr.to != BreakerOpen {
+		t.Fatalf("transition %v", tr)
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatal("open breaker allowed a task")
+	}
+	if n, _ := b.pullable(0); n != 0 {
+		t.Fatalf("open breaker pullable %d", n)
+	}
+	if d := b.retryAfter(); d <= 0 || d > 30*time.Millisecond {
+		t.Fatalf("retry after %v", d)
+	}
+
+	// 超时后半开, 只拉取和放行探测名额
+	time.Sleep(40 * time.Millisecond)
+	n, tr := b.pullable(0)
+	if tr.to != BreakerHalfOpen || n != 2 {
+		t.Fatalf("half-open pullable %d, transition %v", n, tr)
+	}
+	if n, _ := b.pullable(1); n != 1 {
+		t.Fatalf("pullable with 1 inflight: %d", n)
+	}
+	b.allow()
+	b.allow()
+	if ok, _ := b.allow(); ok {
+		t.Fatal("allowed more than HalfOpenProbes")
+	}
+	if n, _ := b.pullable(0); n != 0 {
+		t.Fatalf("pullable after probes used: %d", n)
+	}
+	b.done(false)
+	if tr := b.done(false); tr.to != BreakerClosed {
+		t.Fatalf("transition %v", tr)
+	}
+	if n, _ := b.pullable(100); n >= 0 {
+		t.Fatalf("closed breaker pullable %d", n)
+	}
+
+	// 半开时任一探测失败重新打开
+	b.setState(BreakerHalfOpen)
+	b.allow()
+	if tr := b.done(true); tr.to != BreakerOpen {
+		t.Fatalf("transition %v", tr)
+	}
+}
+
+// 熔断拒绝的任务延迟重新入队并ack, 不会丢失
+func TestBreakerRejectRequeue(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	j.AddFunc(q, "b", func(ctx context.Context, task *Task) {}, 1)
+	var changes []BreakerState
+	j.RegisterBreakerCallback(func(topic string, from, to BreakerState) { changes = append(changes, to) })
+	if err := j.SetCircuitBreaker("b", &BreakerConfig{MinRequests: 1, OpenTimeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := j.getWorker("b")
+	w.breakerDone(true)
+	if w.BreakerState() != BreakerOpen || len(changes) != 1 || changes[0] != BreakerOpen {
+		t.Fatalf("state %v, changes %v", w.BreakerState(), changes)
+	}
+
+	task := GenTask("b", "m")
+	task.Token = "tok"
+	if w.breakerAllow(&task) {
+		t.Fatal("open breaker allowed a task")
+	}
+	msgs := q.messages("b")
+	if len(msgs) != 1 {
+		t.Fatalf("requeued %d messages", len(msgs))
+	}
+	requeued, err := DecodeStringTask(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued.Id != task.Id || requeued.Message != "m" || requeued.Token != "" {
+		t.Fatalf("requeued %+v", requeued)
+	}
+	if d := time.Until(time.Unix(0, requeued.NotBefore*int64(time.Millisecond))); d < 900*time.Millisecond {
+		t.Fatalf("requeued with delay %v", d)
+	}
+	if atomic.LoadInt64(&q.acks) != 1 {
+		t.Fatalf("acks %d", q.acks)
+	}
+	if stats, _ := j.TopicStats("b"); stats["breaker_rejected"] != 1 || stats["breaker_state"] != int64(BreakerOpen) {
+		t.Fatal(stats)
+	}
+}
+
+// 熔断打开时不拉取, 半开时每次最多拉取剩余的探测名额
+func TestBreakerGatesPulling(t *testing.T) {
+	q := &batchQueue{memQueue: newMemQueue()}
+	j := New()
+	j.AddFunc(q, "b", func(ctx context.Context, task *Task) {}, 8)
+	j.SetCircuitBreaker("b", &BreakerConfig{MinRequests: 1, OpenTimeout: 50 * time.Millisecond, HalfOpenProbes: 2})
+	w, _ := j.getWorker("b")
+	w.breakerDone(true)
+
+	msgs := make([]string, 20)
+	for i := range msgs {
+		msgs[i] = "m"
+	}
+	j.BatchEnqueue(context.Background(), "b", msgs)
+	j.Start()
+	defer stopJob(t, j)
+
+	time.Sleep(20 * time.Millisecond)
+	if q.len("b") != 20 {
+		t.Fatal("pulled while breaker open")
+	}
+	waitFor(t, 2*time.Second, func() bool { return w.BreakerState() == BreakerClosed })
+	waitFor(t, 2*time.Second, func() bool { return q.len("b") == 0 })
+	if stats, _ := j.TopicStats("b"); stats["breaker_rejected"] != 0 {
+		t.Fatal(stats)
+	}
+}
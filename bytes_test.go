@@ -0,0 +1,106 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/navi-tt/job/internal/queue"
+)
+
+// 二进制消息的内存队列, string接口不可用
+type bytesQueue struct {
+	*memQueue
+	mu    sync.Mutex
+	items map[string][][]byte
+}
+
+func newBytesQueue() *bytesQueue {
+	return &bytesQueue{memQueue: newMemQueue(), items: make(map[string][][]byte)}
+}
+
+func (q *bytesQueue) Enqueue(ctx context.Context, key string, message string, args ...interface{}) (bool, error) {
+	panic("string enqueue on bytes queue")
+}
+
+func (q *bytesQueue) BatchEnqueue(ctx context.Context, key string, messages []string, args ...interface{}) (bool, error) {
+	panic("string enqueue on bytes queue")
+}
+
+func (q *bytesQueue) Dequeue(ctx context.Context, key string, args ...interface{}) (string, string, int64, error) {
+	panic("string dequeue on bytes queue")
+}
+
+func (q *bytesQueue) EnqueueBytes(ctx context.Context, key string, message []byte, args ...interface{}) (bool, error) {
+	return q.BatchEnqueueBytes(ctx, key, [][]byte{message}, args...)
+}
+
+func (q *bytesQueue) BatchEnqueueBytes(ctx context.Context, key string, messages [][]byte, args ...interface{}) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range messages {
+		q.items[key] = append(q.items[key], append([]byte(nil), m...))
+	}
+	return true, nil
+}
+
+func (q *bytesQueue) DequeueBytes(ctx context.Context, key string, args ...interface{}) ([]byte, string, int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items[key]) == 0 {
+		return nil, "", 0, queue.ErrNil
+	}
+	m := q.items[key][0]
+	q.items[key] = q.items[key][1:]
+	return m, "tok", 1, nil
+}
+
+func (q *bytesQueue) first(key string) []byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.items[key][0]
+}
+
+// 二进制消息不经过string转换, 每种编解码器的Payload原样送达
+func TestBytesPayload(t *testing.T) {
+	payload := []byte{0, 0xfe, 0xff, '~', 0x80, 0}
+	for _, c := range []Codec{JSONCodec{}, MsgpackCodec{}, ProtobufCodec{}} {
+		q := newBytesQueue()
+		j := New()
+		j.SetCodec(c, "b")
+		executed := make(chan []byte, 1)
+		j.AddFunc(q, "b", func(ctx context.Context, task *Task) { executed <- task.Body() }, 1)
+		if ok, err := j.EnqueueBytes(context.Background(), "b", payload); !ok || err != nil {
+			t.Fatal(c.ContentType(), err)
+		}
+		// 二进制队列不使用文本信封
+		if m := q.first("b"); c.ContentType() != ContentTypeJSON && !isEnvelope(m) {
+			t.Fatalf("%s: message %q", c.ContentType(), m)
+		}
+
+		j.Start()
+		select {
+		case got := <-executed:
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("%s: payload %v, want %v", c.ContentType(), got, payload)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal(c.ContentType(), "task not executed")
+		}
+		stopJob(t, j)
+	}
+}
+
+// 没有Payload时Body返回Message
+func TestTaskBody(t *testing.T) {
+	task := GenTask("t", "m")
+	if string(task.Body()) != "m" {
+		t.Fatal(task.Body())
+	}
+	task = GenBytesTask("t", []byte{})
+	if task.Body() == nil || len(task.Body()) != 0 {
+		t.Fatal(task.Body())
+	}
+}
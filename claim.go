@@ -0,0 +1,215 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/navi-tt/job/internal/log"
+)
+
+const (
+	//默认claim-check阈值，Message和Payload合计超过该字节数时存入ClaimStore
+	DefaultClaimThreshold = 256 * 1024
+)
+
+var (
+	ErrClaimNotExist   = errors.New("claim is not exists")
+	ErrClaimStoreEmpty = errors.New("claim store is not set")
+	ErrInvalidClaimKey = errors.New("invalid claim key")
+)
+
+// 大消息存储(claim-check), 消息体超过阈值时存入store, 队列中只传递引用
+// 存储的内容为: 类型(1字节, 0为Message, 1为Payload) + 消息体
+type ClaimStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	// key不存在时返回ErrClaimNotExist
+	Get(ctx context.Context, key string) ([]byte, error)
+	// key不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+}
+
+const (
+	claimMessage = 0
+	claimPayload = 1
+)
+
+// topic的claim-check配置
+type claimCheck struct {
+	store     ClaimStore
+	threshold int
+}
+
+// 消息体超过阈值时存入store并替换为引用, 返回是否已存入
+func (cc claimCheck) offload(ctx context.Context, task *Task) (bool, error) {
+	if cc.store == nil || task.ClaimRef != "" || len(task.Message)+len(task.Payload) <= cc.threshold {
+		return false, nil
+	}
+	var data []byte
+	if task.Payload != nil {
+		data = append([]byte{claimPayload}, task.Payload...)
+	} else {
+		data = append([]byte{claimMessage}, task.Message...)
+	}
+	key := GenUUID()
+	if err := cc.store.Put(ctx, key, data); err != nil {
+		return false, err
+	}
+	task.ClaimRef = key
+	task.Message = ""
+	task.Payload = nil
+	return true, nil
+}
+
+// 入队失败时删除已经存入的消息体
+func (cc claimCheck) discard(ctx context.Context, task *Task) {
+	if err := cc.store.Delete(ctx, task.ClaimRef); err != nil {
+		log.Errorf("claim_delete_error: %v, %v", err, task.ClaimRef)
+	}
+}
+
+// 执行前取回消息体, 失败时不执行
+// 消息体不存在或者引用不合法时重试没有意义, 任务按StateFailedWithAck处理, 设置了死信topic时先转入死信队列, 转入失败时不ack
+// 其他错误按StateFailed处理, 不ack, 如果queue服务支持, 会进行消息重放
+func (w *WorkerWithFunc) resolveClaim(task *Task) bool {
+	if task.ClaimRef == "" {
+		return true
+	}
+	err := ErrClaimStoreEmpty
+	if !validClaimRef(task.ClaimRef) {
+		err = fmt.Errorf("%w: %q", ErrInvalidClaimKey, task.ClaimRef)
+	} else if store := w.Job().producer.getClaimCheck(w.Topic()).store; store != nil {
+		var data []byte
+		if data, err = store.Get(w.Job().ctx, task.ClaimRef); err == nil {
+			err = restoreClaim(task, data)
+		}
+	}
+	if err == nil {
+		return true
+	}
+	log.Errorf("claim_resolve_error: %v, %v", err, task.ClaimRef)
+	task.Result = Result{State: StateFailed, Message: err.Error()}
+	if errors.Is(err, ErrClaimNotExist) || errors.Is(err, ErrInvalidClaimKey) {
+		if w.forward(task.Bytes(), err) || w.DeadLetter() == "" {
+			task.Result.State = StateFailedWithAck
+		}
+	}
+	return false
+}
+
+// 引用必须是offload生成的uuid, 防止伪造的消息读取或删除store中的其他内容
+func validClaimRef(ref string) bool {
+	u, err := uuid.Parse(ref)
+	return err == nil && u.String() == ref
+}
+
+// store的key不能为空, 不能是绝对路径或者包含路径分隔符, 不能是"."或".."
+func checkClaimKey(key string) error {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) ||
+		filepath.IsAbs(key) || filepath.VolumeName(key) != "" {
+		return fmt.Errorf("%w: %q", ErrInvalidClaimKey, key)
+	}
+	return nil
+}
+
+func restoreClaim(task *Task, data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%w: %s", ErrClaimNotExist, task.ClaimRef)
+	}
+	switch data[0] {
+	case claimMessage:
+		task.Message = string(data[1:])
+	case claimPayload:
+		task.Payload = data[1:]
+	default:
+		return fmt.Errorf("invalid claim type %d", data[0])
+	}
+	return nil
+}
+
+// ack成功后删除存储的消息体, 删除失败只记录日志
+func (w *WorkerWithFunc) releaseClaim(task *Task) {
+	if task.ClaimRef == "" || !validClaimRef(task.ClaimRef) {
+		return
+	}
+	store := w.Job().producer.getClaimCheck(w.Topic()).store
+	if store == nil {
+		return
+	}
+	if err := store.Delete(w.Job().ctx, task.ClaimRef); err != nil {
+		log.Errorf("claim_delete_error: %v, %v", err, task.ClaimRef)
+	}
+}
+
+// 本地文件系统存储, 多个进程共享时需要使用共享目录, 如NFS
+type FileClaimStore struct {
+	dir string
+}
+
+func NewFileClaimStore(dir string) (*FileClaimStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileClaimStore{dir: dir}, nil
+}
+
+func (s *FileClaimStore) path(key string) (string, error) {
+	if err := checkClaimKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// 先写临时文件再重命名, 读取时不会读到写了一半的文件
+func (s *FileClaimStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(s.dir, ".tmp-"+key)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *FileClaimStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrClaimNotExist, key)
+	}
+	return data, err
+}
+
+func (s *FileClaimStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package job
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgpack  = "application/msgpack"
+	ContentTypeProtobuf = "application/protobuf"
+
+	//信封头：任务编码格式
+	HeaderContentType = "content-type"
+)
+
+const (
+	//信封起始字节，不会出现在JSON和UTF-8文本的开头
+	envelopeMagic   = 0xFE
+	envelopeVersion = 1
+	//文本信封起始字节，后面是base64编码的信封，用于只能传递UTF-8文本的队列驱动
+	textEnvelopeMagic = '~'
+)
+
+var (
+	ErrCodecNotExist   = errors.New("codec is not exists")
+	ErrInvalidEnvelope = errors.New("invalid task envelope")
+)
+
+// 任务编解码器
+// Token/DequeueCount/Result为消费端状态, 二进制编解码器可以不编码
+type Codec interface {
+	ContentType() string
+	Encode(task *Task) ([]byte, error)
+	Decode(data []byte, task *Task) error
+}
+
+var (
+	codecs   = make(map[string]Codec)
+	codecsMu sync.RWMutex
+)
+
+// 注册编解码器, 消费端按信封中的content-type查找编解码器
+// 同一个content-type注册两次或者codec为nil时panic
+func RegisterCodec(c Codec) {
+	if c == nil {
+		panic("job: RegisterCodec codec is nil")
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, dup := codecs[c.ContentType()]; dup {
+		panic("job: RegisterCodec called twice for content type " + c.ContentType())
+	}
+	codecs[c.ContentType()] = c
+}
+
+func GetCodec(contentType string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCodecNotExist, contentType)
+	}
+	return c, nil
+}
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(MsgpackCodec{})
+	RegisterCodec(ProtobufCodec{})
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Encode(task *Task) ([]byte, error) {
+	return json.Marshal(task)
+}
+
+func (JSONCodec) Decode(data []byte, task *Task) error {
+	return json.Unmarshal(data, task)
+}
+
+// 任务信封: 头信息 + 编码后的任务
+// 格式: magic(1) version(1) 头数量(uvarint) [key长度(uvarint) key value长度(uvarint) value]... body
+// 不带信封的消息按JSON解码, 兼容旧版本的生产者
+// 信封是二进制数据, 不是合法的UTF-8, 队列驱动没有实现queue.BytesQueue时按文本信封入队: '~' + base64(信封)
+type envelope struct {
+	headers map[string]string
+	body    []byte
+}
+
+func (e *envelope) set(key, value string) {
+	if e.headers == nil {
+		e.headers = make(map[string]string)
+	}
+	e.headers[key] = value
+}
+
+func (e *envelope) get(key string) string {
+	return e.headers[key]
+}
+
+func (e *envelope) marshal() []byte {
+	keys := make([]string, 0, len(e.headers))
+	size := 2 + binary.MaxVarintLen64 + len(e.body)
+	for k, v := range e.headers {
+		keys = append(keys, k)
+		size += 2*binary.MaxVarintLen64 + len(k) + len(v)
+	}
+	sort.Strings(keys)
+
+	b := make([]byte, 0, size)
+	b = append(b, envelopeMagic, envelopeVersion)
+	b = appendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = appendBytes(b, []byte(k))
+		b = appendBytes(b, []byte(e.headers[k]))
+	}
+	return append(b, e.body...)
+}
+
+func isEnvelope(data []byte) bool {
+	return len(data) > 0 && data[0] == envelopeMagic
+}
+
+// 转换为文本信封, 不是信封的消息原样返回
+func textEnvelope(data []byte) []byte {
+	if !isEnvelope(data) {
+		return data
+	}
+	b := make([]byte, 1+base64.StdEncoding.EncodedLen(len(data)))
+	b[0] = textEnvelopeMagic
+	base64.StdEncoding.Encode(b[1:], data)
+	return b
+}
+
+// 文本信封转换为二进制信封, 不是文本信封的消息原样返回
+func binaryEnvelope(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != textEnvelopeMagic {
+		return data, nil
+	}
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(data)-1))
+	n, err := base64.StdEncoding.Decode(b, data[1:])
+	if err != nil || !isEnvelope(b[:n]) {
+		return nil, ErrInvalidEnvelope
+	}
+	return b[:n], nil
+}
+
+func unmarshalEnvelope(data []byte) (*envelope, error) {
+	if len(data) < 2 || data[0] != envelopeMagic || data[1] != envelopeVersion {
+		return nil, ErrInvalidEnvelope
+	}
+	data = data[2:]
+	n, data, err := readUvarint(data)
+	if err != nil {
+		return nil, err
+	}
+	// 数量来自输入, 每个头至少2字节, 按剩余长度限制预分配的大小
+	size := n
+	if max := uint64(len(data) / 2); size > max {
+		size = max
+	}
+	e := &envelope{headers: make(map[string]string, size)}
+	for i := uint64(0); i < n; i++ {
+		var k, v []byte
+		if k, data, err = readBytes(data); err != nil {
+			return nil, err
+		}
+		if v, data, err = readBytes(data); err != nil {
+			return nil, err
+		}
+		e.headers[string(k)] = string(v)
+	}
+	e.body = data
+	return e, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendBytes(b []byte, v []byte) []byte {
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, ErrInvalidEnvelope
+	}
+	return v, data[n:], nil
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	n, data, err := readUvarint(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint64(len(data)) < n {
+		return nil, nil, ErrInvalidEnvelope
+	}
+	return data[:n], data[n:], nil
+}
+
+// 按编解码器编码任务, JSON不加信封, 兼容旧版本的消费端
+func EncodeTask(task *Task, c Codec) ([]byte, error) {
+	return encodeTask(task, c)
+}
+
+// 编码后处理信封, 如压缩、加密、签名, 按顺序执行
+type envelopeFunc func(e *envelope) error
+
+// 编码任务, 除content-type外没有其他头信息的JSON消息不加信封
+func encodeTask(task *Task, c Codec, fns ...envelopeFunc) ([]byte, error) {
+	if c == nil {
+		c = JSONCodec{}
+	}
+	body, err := c.Encode(task)
+	if err != nil {
+		return nil, err
+	}
+	e := &envelope{body: body}
+	e.set(HeaderContentType, c.ContentType())
+	for _, fn := range fns {
+		if err := fn(e); err != nil {
+			return nil, err
+		}
+	}
+	if c.ContentType() == ContentTypeJSON && len(e.headers) == 1 {
+		return e.body, nil
+	}
+	return e.marshal(), nil
+}
+
+// 消费端解码选项, DecodeStringTask/DecodeBytesTask不带选项
+type decodeOptions struct {
+	topic   string      // 出队的topic, 验签和解密时使用
+	keys    KeyProvider // 解密密钥, 不为nil时拒绝没有加密的消息
+	signing *HMACKeys   // 验签密钥, 不为nil时拒绝没有签名的消息
+}
+
+func decodeTask(b []byte, opts decodeOptions) (t Task, err error) {
+	if b, err = binaryEnvelope(b); err != nil {
+		return t, err
+	}
+	if isEnvelope(b) {
+		e, err := unmarshalEnvelope(b)
+		if err != nil {
+			return t, err
+		}
+		if opts.signing != nil {
+			if err = verifyEnvelope(e, opts.topic, opts.signing); err != nil {
+				return t, err
+			}
+		}
+		return decodeEnvelopeTask(e, opts)
+	}
+	if opts.signing != nil {
+		return t, fmt.Errorf("%w: unsigned message", ErrInvalidSignature)
+	}
+	if opts.keys != nil {
+		return t, fmt.Errorf("%w: unencrypted message", ErrDecryptFailed)
+	}
+	err = json.Unmarshal(b, &t)
+	return
+}
+
+// 解码信封中的任务, 依次解密、解压, 再按content-type查找编解码器
+func decodeEnvelopeTask(e *envelope, opts decodeOptions) (t Task, err error) {
+	if err = decryptEnvelope(e, opts.topic, opts.keys); err != nil {
+		return t, err
+	}
+	if err = decompressEnvelope(e); err != nil {
+		return t, err
+	}
+	ct := e.get(HeaderContentType)
+	if ct == "" {
+		ct = ContentTypeJSON
+	}
+	c, err := GetCodec(ct)
+	if err != nil {
+		return t, err
+	}
+	err = c.Decode(e.body, &t)
+	return t, err
+}
@@ -0,0 +1,385 @@
+package job
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+var errMsgpack = errors.New("invalid msgpack task")
+
+// 跳过未知字段时允许的最大嵌套层数
+const mpMaxDepth = 32
+
+// MessagePack编解码器, 任务编码为map, key与JSON一致, 消费端状态不编码
+// Payload编码为bin, 解码时直接引用输入的缓冲区, 不拷贝
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ContentType() string {
+	return ContentTypeMsgpack
+}
+
+func (MsgpackCodec) Encode(task *Task) ([]byte, error) {
+	fields := 3
+	if task.Payload != nil {
+		fields++
+	}
+	if task.PartitionKey != "" {
+		fields++
+	}
+	if len(task.Headers) > 0 {
+		fields++
+	}
+	if task.EnqueuedAt != 0 {
+		fields++
+	}
+	if task.FirstAttemptAt != 0 {
+		fields++
+	}
+	if task.NotBefore != 0 {
+		fields++
+	}
+	if task.ClaimRef != "" {
+		fields++
+	}
+
+	b := make([]byte, 0, 64+len(task.Message)+len(task.Payload))
+	b = mpAppendMapHeader(b, fields)
+	b = mpAppendStr(mpAppendStr(b, "id"), task.Id)
+	b = mpAppendStr(mpAppendStr(b, "topic"), task.Topic)
+	b = mpAppendStr(mpAppendStr(b, "message"), task.Message)
+	if task.Payload != nil {
+		b = mpAppendBin(mpAppendStr(b, "payload"), task.Payload)
+	}
+	if task.PartitionKey != "" {
+		b = mpAppendStr(mpAppendStr(b, "partition_key"), task.PartitionKey)
+	}
+	if len(task.Headers) > 0 {
+		b = mpAppendStr(b, "headers")
+		keys := make([]string, 0, len(task.Headers))
+		for k := range task.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = mpAppendMapHeader(b, len(keys))
+		for _, k := range keys {
+			b = mpAppendStr(mpAppendStr(b, k), task.Headers[k])
+		}
+	}
+	if task.EnqueuedAt != 0 {
+		b = mpAppendInt(mpAppendStr(b, "enqueued_at"), task.EnqueuedAt)
+	}
+	if task.FirstAttemptAt != 0 {
+		b = mpAppendInt(mpAppendStr(b, "first_attempt_at"), task.FirstAttemptAt)
+	}
+	if task.NotBefore != 0 {
+		b = mpAppendInt(mpAppendStr(b, "not_before"), task.NotBefore)
+	}
+	if task.ClaimRef != "" {
+		b = mpAppendStr(mpAppendStr(b, "claim_ref"), task.ClaimRef)
+	}
+	return b, nil
+}
+
+func (MsgpackCodec) Decode(data []byte, task *Task) error {
+	r := &mpReader{data: data}
+	n, err := r.mapHeader()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		key, err := r.str()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "id":
+			task.Id, err = r.str()
+		case "topic":
+			task.Topic, err = r.str()
+		case "message":
+			task.Message, err = r.str()
+		case "payload":
+			task.Payload, err = r.bin()
+		case "partition_key":
+			task.PartitionKey, err = r.str()
+		case "headers":
+			var m int
+			if m, err = r.mapHeader(); err != nil {
+				return err
+			}
+			// 数量来自输入, 每一项至少2字节, 按剩余长度限制预分配的大小
+			task.Headers = make(map[string]string, r.clamp(m, 2))
+			for j := 0; j < m; j++ {
+				var k, v string
+				if k, err = r.str(); err != nil {
+					return err
+				}
+				if v, err = r.str(); err != nil {
+					return err
+				}
+				task.Headers[k] = v
+			}
+		case "enqueued_at":
+			task.EnqueuedAt, err = r.int()
+		case "first_attempt_at":
+			task.FirstAttemptAt, err = r.int()
+		case "not_before":
+			task.NotBefore, err = r.int()
+		case "claim_ref":
+			task.ClaimRef, err = r.str()
+		default:
+			err = r.skip(0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mpAppendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	return append(b, 0xdf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func mpAppendStr(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, s...)
+}
+
+func mpAppendBin(b []byte, v []byte) []byte {
+	n := len(v)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xc6, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, v...)
+}
+
+func mpAppendInt(b []byte, v int64) []byte {
+	if v >= 0 && v < 128 {
+		return append(b, byte(v))
+	}
+	b = append(b, 0xd3)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+	return append(b, buf[:]...)
+}
+
+type mpReader struct {
+	data []byte
+	pos  int
+}
+
+// 按剩余长度限制元素数量, size为每个元素至少占用的字节数
+func (r *mpReader) clamp(n int, size int) int {
+	if max := (len(r.data) - r.pos) / size; n > max {
+		return max
+	}
+	return n
+}
+
+func (r *mpReader) next(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errMsgpack
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *mpReader) byte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// 读取大端无符号整数
+func (r *mpReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (r *mpReader) mapHeader() (int, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c&0xf0 == 0x80:
+		return int(c & 0x0f), nil
+	case c == 0xde:
+		n, err := r.uint(2)
+		return int(n), err
+	case c == 0xdf:
+		n, err := r.uint(4)
+		return int(n), err
+	}
+	return 0, errMsgpack
+}
+
+// 读取str或bin, nil返回空字符串
+func (r *mpReader) str() (string, error) {
+	b, err := r.bin()
+	return string(b), err
+}
+
+// 读取str或bin, 返回的切片引用输入的缓冲区, nil返回nil
+func (r *mpReader) bin() ([]byte, error) {
+	c, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	var n uint64
+	switch {
+	case c&0xe0 == 0xa0:
+		n = uint64(c & 0x1f)
+	case c == 0xc0:
+		return nil, nil
+	case c == 0xd9 || c == 0xc4:
+		n, err = r.uint(1)
+	case c == 0xda || c == 0xc5:
+		n, err = r.uint(2)
+	case c == 0xdb || c == 0xc6:
+		n, err = r.uint(4)
+	default:
+		return nil, errMsgpack
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.next(int(n))
+}
+
+func (r *mpReader) int() (int64, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c < 0x80:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c == 0xc0:
+		return 0, nil
+	case c >= 0xcc && c <= 0xcf:
+		v, err := r.uint(1 << (c - 0xcc))
+		return int64(v), err
+	case c >= 0xd0 && c <= 0xd3:
+		size := 1 << (c - 0xd0)
+		v, err := r.uint(size)
+		shift := uint(64 - size*8)
+		return int64(v<<shift) >> shift, err
+	}
+	return 0, errMsgpack
+}
+
+// 跳过一个未知的值, 嵌套超过mpMaxDepth层时返回错误
+func (r *mpReader) skip(depth int) error {
+	if depth > mpMaxDepth {
+		return errMsgpack
+	}
+	c, err := r.byte()
+	if err != nil {
+		return err
+	}
+	var size, items uint64
+	switch {
+	case c < 0x80 || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		return nil
+	case c&0xf0 == 0x80:
+		items = uint64(c&0x0f) * 2
+	case c&0xf0 == 0x90:
+		items = uint64(c & 0x0f)
+	case c&0xe0 == 0xa0:
+		size = uint64(c & 0x1f)
+	case c == 0xc4 || c == 0xd9:
+		size, err = r.uint(1)
+	case c == 0xc5 || c == 0xda:
+		size, err = r.uint(2)
+	case c == 0xc6 || c == 0xdb:
+		size, err = r.uint(4)
+	case c == 0xcc || c == 0xd0:
+		size = 1
+	case c == 0xcd || c == 0xd1:
+		size = 2
+	case c == 0xca || c == 0xce || c == 0xd2:
+		size = 4
+	case c == 0xcb || c == 0xcf || c == 0xd3:
+		size = 8
+	case c == 0xd4:
+		size = 2
+	case c == 0xd5:
+		size = 3
+	case c == 0xd6:
+		size = 5
+	case c == 0xd7:
+		size = 9
+	case c == 0xd8:
+		size = 17
+	case c == 0xc7:
+		size, err = r.uint(1)
+		size++
+	case c == 0xc8:
+		size, err = r.uint(2)
+		size++
+	case c == 0xc9:
+		size, err = r.uint(4)
+		size++
+	case c == 0xdc:
+		items, err = r.uint(2)
+	case c == 0xdd:
+		items, err = r.uint(4)
+	case c == 0xde:
+		items, err = r.uint(2)
+		items *= 2
+	case c == 0xdf:
+		items, err = r.uint(4)
+		items *= 2
+	default:
+		return errMsgpack
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = r.next(int(size)); err != nil {
+		return err
+	}
+	for i := uint64(0); i < items; i++ {
+		if err = r.skip(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
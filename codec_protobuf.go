@@ -0,0 +1,175 @@
+package job
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+var errProtobuf = errors.New("invalid protobuf task")
+
+// Protobuf编解码器, 按以下结构编码, 其他语言的生产者和消费者可以用该定义生成代码:
+//
+//	syntax = "proto3";
+//	message Task {
+//	  string id = 1;
+//	  string topic = 2;
+//	  bytes message = 3;
+//	  string partition_key = 4;
+//	  map<string, string> headers = 5;
+//	  int64 enqueued_at = 6;
+//	  int64 first_attempt_at = 7;
+//	  int64 not_before = 8;
+//	  bytes payload = 9;
+//	  string claim_ref = 10;
+//	}
+//
+// payload解码时直接引用输入的缓冲区, 不拷贝
+type ProtobufCodec struct{}
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+func (ProtobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtobufCodec) Encode(task *Task) ([]byte, error) {
+	b := make([]byte, 0, 64+len(task.Message)+len(task.Payload))
+	b = pbAppendString(b, 1, task.Id)
+	b = pbAppendString(b, 2, task.Topic)
+	b = pbAppendString(b, 3, task.Message)
+	b = pbAppendString(b, 4, task.PartitionKey)
+
+	keys := make([]string, 0, len(task.Headers))
+	for k := range task.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// map的每一项编码为 {1: key, 2: value}
+		entry := pbAppendString(nil, 1, k)
+		entry = pbAppendString(entry, 2, task.Headers[k])
+		b = pbAppendTag(b, 5, pbBytes)
+		b = appendBytes(b, entry)
+	}
+
+	b = pbAppendInt(b, 6, task.EnqueuedAt)
+	b = pbAppendInt(b, 7, task.FirstAttemptAt)
+	b = pbAppendInt(b, 8, task.NotBefore)
+	if len(task.Payload) > 0 {
+		b = pbAppendTag(b, 9, pbBytes)
+		b = appendBytes(b, task.Payload)
+	}
+	b = pbAppendString(b, 10, task.ClaimRef)
+	return b, nil
+}
+
+func (ProtobufCodec) Decode(data []byte, task *Task) error {
+	for len(data) > 0 {
+		field, typ, v, b, rest, err := pbReadField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		switch {
+		case field == 1 && typ == pbBytes:
+			task.Id = string(b)
+		case field == 2 && typ == pbBytes:
+			task.Topic = string(b)
+		case field == 3 && typ == pbBytes:
+			task.Message = string(b)
+		case field == 4 && typ == pbBytes:
+			task.PartitionKey = string(b)
+		case field == 5 && typ == pbBytes:
+			var key, value string
+			for len(b) > 0 {
+				f, t, _, eb, erest, err := pbReadField(b)
+				if err != nil {
+					return err
+				}
+				b = erest
+				if f == 1 && t == pbBytes {
+					key = string(eb)
+				} else if f == 2 && t == pbBytes {
+					value = string(eb)
+				}
+			}
+			if task.Headers == nil {
+				task.Headers = make(map[string]string)
+			}
+			task.Headers[key] = value
+		case field == 6 && typ == pbVarint:
+			task.EnqueuedAt = int64(v)
+		case field == 7 && typ == pbVarint:
+			task.FirstAttemptAt = int64(v)
+		case field == 8 && typ == pbVarint:
+			task.NotBefore = int64(v)
+		case field == 9 && typ == pbBytes:
+			task.Payload = b
+		case field == 10 && typ == pbBytes:
+			task.ClaimRef = string(b)
+		}
+	}
+	return nil
+}
+
+func pbAppendTag(b []byte, field int, typ int) []byte {
+	return appendUvarint(b, uint64(field)<<3|uint64(typ))
+}
+
+// proto3默认值不编码
+func pbAppendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = pbAppendTag(b, field, pbBytes)
+	return appendBytes(b, []byte(s))
+}
+
+func pbAppendInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = pbAppendTag(b, field, pbVarint)
+	return appendUvarint(b, uint64(v))
+}
+
+// 读取一个字段, varint类型返回v, bytes类型返回b, 其他类型跳过
+func pbReadField(data []byte) (field int, typ int, v uint64, b []byte, rest []byte, err error) {
+	tag, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, 0, 0, nil, nil, errProtobuf
+	}
+	data = data[n:]
+	field, typ = int(tag>>3), int(tag&7)
+	switch typ {
+	case pbVarint:
+		v, n = binary.Uvarint(data)
+		if n <= 0 {
+			return 0, 0, 0, nil, nil, errProtobuf
+		}
+		return field, typ, v, nil, data[n:], nil
+	case pbBytes:
+		b, rest, err = readBytes(data)
+		if err != nil {
+			return 0, 0, 0, nil, nil, errProtobuf
+		}
+		return field, typ, 0, b, rest, nil
+	case pbFixed64:
+		if len(data) < 8 {
+			return 0, 0, 0, nil, nil, errProtobuf
+		}
+		return field, typ, 0, nil, data[8:], nil
+	case pbFixed32:
+		if len(data) < 4 {
+			return 0, 0, 0, nil, nil, errProtobuf
+		}
+		return field, typ, 0, nil, data[4:], nil
+	}
+	return 0, 0, 0, nil, nil, errProtobuf
+}
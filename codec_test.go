@@ -0,0 +1,163 @@
+package job
+
+import (
+	"context"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fullTask() Task {
+	task := GenTask("t", "hello")
+	task.Payload = []byte{0, 1, 0xff}
+	task.PartitionKey = "p"
+	task.Headers = map[string]string{"a": "1", "trace": "x"}
+	task.EnqueuedAt = 1700000000000
+	task.FirstAttemptAt = 1700000000001
+	task.NotBefore = 1700000000002
+	task.ClaimRef = "c"
+	return task
+}
+
+// 与参考实现的编码结果一致的任务
+func referenceTask() Task {
+	task := fullTask()
+	task.Id = "id-1"
+	return task
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSONCodec{}, MsgpackCodec{}, ProtobufCodec{}} {
+		task := fullTask()
+		b, err := EncodeTask(&task, c)
+		if err != nil {
+			t.Fatal(c.ContentType(), err)
+		}
+		got, err := DecodeBytesTask(b)
+		if err != nil {
+			t.Fatal(c.ContentType(), err)
+		}
+		if !reflect.DeepEqual(got, task) {
+			t.Fatalf("%s: got %+v, want %+v", c.ContentType(), got, task)
+		}
+	}
+}
+
+// 参考实现(vmihailenco/msgpack, protobuf dynamicpb)的编码, 带有未知字段
+func TestCodecReference(t *testing.T) {
+	fixtures := []struct {
+		codec Codec
+		hex   string
+	}{
+		{MsgpackCodec{}, "8ba565787472619301a17881a16ec0a26964a469642d31a5746f706963a174a76d657373616765a568656c6c6fa77061796c6f6164c4030001ffab656e7175657565645f6174d30000018bcfe56800b066697273745f617474656d70745f6174d30000018bcfe56801aa6e6f745f6265666f7265d30000018bcfe56802a9636c61696d5f726566a163ad706172746974696f6e5f6b6579a170a76865616465727382a161a131a57472616365a178"},
+		{ProtobufCodec{}, "0a0469642d311201741a0568656c6c6f2201702a060a01611201312a0a0a0574726163651201783080d095ffbc313881d095ffbc314082d095ffbc314a030001ff5201637807"},
+	}
+	want := referenceTask()
+	for _, f := range fixtures {
+		b, _ := hex.DecodeString(f.hex)
+		var got Task
+		if err := f.codec.Decode(b, &got); err != nil {
+			t.Fatal(f.codec.ContentType(), err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %+v, want %+v", f.codec.ContentType(), got, want)
+		}
+	}
+
+	// 字段顺序与参考实现一致, 去掉未知字段后编码结果相同
+	b, _ := ProtobufCodec{}.Encode(&want)
+	if got := hex.EncodeToString(b); got != fixtures[1].hex[:len(fixtures[1].hex)-4] {
+		t.Fatalf("protobuf encoding %s", got)
+	}
+}
+
+// 截断的输入不能panic, msgpack按map数量检查, 必须返回错误
+// protobuf在字段边界截断仍然是合法的消息
+func TestCodecTruncated(t *testing.T) {
+	task := fullTask()
+	for _, c := range []Codec{MsgpackCodec{}, ProtobufCodec{}} {
+		b, _ := EncodeTask(&task, c)
+		for i := 1; i < len(b); i++ {
+			_, err := DecodeBytesTask(b[:i])
+			if err == nil && c.ContentType() == ContentTypeMsgpack {
+				t.Fatalf("%s: truncated at %d decoded", c.ContentType(), i)
+			}
+		}
+	}
+}
+
+func TestCodecGarbage(t *testing.T) {
+	deep := []byte{0x81, 0xa1, 'x'}
+	for i := 0; i < 10000; i++ {
+		deep = append(deep, 0x91)
+	}
+	msgpack := [][]byte{
+		// 数量很大的map, 没有内容
+		{0xdf, 0xff, 0xff, 0xff, 0xff},
+		{0x81, 0xa7, 'h', 'e', 'a', 'd', 'e', 'r', 's', 0xdf, 0xff, 0xff, 0xff, 0xff},
+		// 未知字段为数量很大的数组
+		{0x81, 0xa1, 'x', 0xdd, 0xff, 0xff, 0xff, 0xff},
+		// 长度超过输入的字符串
+		{0x81, 0xa2, 'i', 'd', 0xdb, 0xff, 0xff, 0xff, 0xff},
+		// 未知类型
+		{0x81, 0xa1, 'x', 0xc1},
+		deep,
+	}
+	for k, b := range msgpack {
+		var task Task
+		if err := (MsgpackCodec{}).Decode(b, &task); err == nil {
+			t.Fatalf("msgpack garbage %d decoded", k)
+		}
+	}
+
+	envelopes := [][]byte{
+		{envelopeMagic},
+		{envelopeMagic, 2},
+		// 头数量很大, 没有内容
+		{envelopeMagic, envelopeVersion, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+		{envelopeMagic, envelopeVersion, 1, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		[]byte("~not base64"),
+		[]byte("~" + "eyJpZCI6IjEifQ=="),
+	}
+	for k, b := range envelopes {
+		if _, err := DecodeBytesTask(b); err == nil {
+			t.Fatalf("envelope garbage %d decoded", k)
+		}
+	}
+}
+
+// 只能传递文本的队列驱动, 二进制信封按base64入队
+func TestTextEnvelope(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	j.SetCodec(MsgpackCodec{}, "t")
+	executed := make(chan *Task, 1)
+	j.AddFunc(q, "t", func(ctx context.Context, task *Task) { executed <- task }, 1)
+	task := fullTask()
+	task.ClaimRef = ""
+	if ok, err := j.EnqueueWithTask(context.Background(), "t", task); !ok || err != nil {
+		t.Fatal(err)
+	}
+	m := q.messages("t")[0]
+	if !strings.HasPrefix(m, "~") || strings.ContainsRune(m, envelopeMagic) {
+		t.Fatalf("message %q", m)
+	}
+	if got, err := DecodeStringTask(m); err != nil || got.Message != task.Message {
+		t.Fatal(got, err)
+	}
+
+	j.Start()
+	defer stopJob(t, j)
+	select {
+	case got := <-executed:
+		if got.Id != task.Id || !reflect.DeepEqual(got.Payload, task.Payload) || got.GetHeader("trace") != "x" {
+			t.Fatalf("task %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("task not executed")
+	}
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt64(&q.acks) == 1 })
+}
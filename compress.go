@@ -0,0 +1,298 @@
+package job
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip   = "gzip"
+	EncodingSnappy = "snappy"
+	EncodingZstd   = "zstd"
+
+	//信封头：消息体压缩格式
+	HeaderContentEncoding = "content-encoding"
+
+	//默认压缩阈值，编码后超过该字节数才压缩
+	DefaultCompressThreshold = 1024
+
+	//默认解压后的最大字节数
+	DefaultMaxDecompressedSize = 64 << 20
+)
+
+var (
+	ErrCompressorNotExist   = errors.New("compressor is not exists")
+	ErrDecompressedTooLarge = errors.New("decompressed message too large")
+)
+
+// 解压后的最大字节数, 防止很小的压缩消息解压后耗尽内存
+var maxDecompressedSize int64 = DefaultMaxDecompressedSize
+
+// 设置解压后的最大字节数, 超过时返回ErrDecompressedTooLarge, 消息进入死信队列
+// size<=0时使用DefaultMaxDecompressedSize
+func SetMaxDecompressedSize(size int) {
+	if size <= 0 {
+		size = DefaultMaxDecompressedSize
+	}
+	atomic.StoreInt64(&maxDecompressedSize, int64(size))
+}
+
+func getMaxDecompressedSize() int64 {
+	return atomic.LoadInt64(&maxDecompressedSize)
+}
+
+// 压缩算法, 消费端按信封中的content-encoding查找并自动解压
+type Compressor interface {
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	compressors   = make(map[string]Compressor)
+	compressorsMu sync.RWMutex
+)
+
+// 注册压缩算法, 同一个encoding注册两次或者c为nil时panic
+func RegisterCompressor(c Compressor) {
+	if c == nil {
+		panic("job: RegisterCompressor compressor is nil")
+	}
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if _, dup := compressors[c.Encoding()]; dup {
+		panic("job: RegisterCompressor called twice for encoding " + c.Encoding())
+	}
+	compressors[c.Encoding()] = c
+}
+
+func GetCompressor(encoding string) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[encoding]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCompressorNotExist, encoding)
+	}
+	return c, nil
+}
+
+func init() {
+	RegisterCompressor(GzipCompressor{})
+	RegisterCompressor(SnappyCompressor{})
+	RegisterCompressor(ZstdCompressor{})
+}
+
+// gzip压缩, Level为0时使用gzip.DefaultCompression
+type GzipCompressor struct {
+	Level int
+}
+
+func (GzipCompressor) Encoding() string {
+	return EncodingGzip
+}
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	max := getMaxDecompressedSize()
+	b, err := ioutil.ReadAll(io.LimitReader(zr, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, ErrDecompressedTooLarge
+	}
+	return b, nil
+}
+
+// snappy块格式压缩, 速度快, 压缩率低于gzip
+type SnappyCompressor struct{}
+
+func (SnappyCompressor) Encoding() string {
+	return EncodingSnappy
+}
+
+func (SnappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+// 解压前按块头中记录的长度检查大小
+func (SnappyCompressor) Decompress(data []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > getMaxDecompressedSize() {
+		return nil, ErrDecompressedTooLarge
+	}
+	return snappy.Decode(nil, data)
+}
+
+// zstd压缩, 压缩率接近gzip, 速度接近snappy, Level为0时使用zstd.SpeedDefault
+type ZstdCompressor struct {
+	Level zstd.EncoderLevel
+}
+
+// zstd编码器默认的窗口大小
+const zstdWindowSize = 8 << 20
+
+// 每个压缩级别共用一个Encoder, EncodeAll可以并发调用
+var zstdEncoders sync.Map
+
+func (ZstdCompressor) Encoding() string {
+	return EncodingZstd
+}
+
+func (c ZstdCompressor) Compress(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = zstd.SpeedDefault
+	}
+	enc, ok := zstdEncoders.Load(level)
+	if !ok {
+		e, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+		if err != nil {
+			return nil, err
+		}
+		enc, _ = zstdEncoders.LoadOrStore(level, e)
+	}
+	return enc.(*zstd.Encoder).EncodeAll(data, nil), nil
+}
+
+// 流式解压并限制读取的字节数, 帧头声明的大小超过上限时直接返回
+// 窗口可以大于消息本身, 解码器内存按上限和默认窗口中较大的限制
+func (ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+	max := getMaxDecompressedSize()
+	var h zstd.Header
+	if err := h.Decode(data); err != nil {
+		return nil, err
+	}
+	if h.HasFCS && h.FrameContentSize > uint64(max) {
+		return nil, ErrDecompressedTooLarge
+	}
+	memory := uint64(max)
+	if memory < zstdWindowSize {
+		memory = zstdWindowSize
+	}
+	zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(memory))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(zr, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, ErrDecompressedTooLarge
+	}
+	return b, nil
+}
+
+// topic的压缩配置
+type compression struct {
+	c         Compressor
+	threshold int
+}
+
+// 返回压缩信封消息体的函数, 小于阈值或者压缩后没有变小时不压缩
+func (p *Producer) compress(cp compression) envelopeFunc {
+	return func(e *envelope) error {
+		if cp.c == nil || len(e.body) < cp.threshold {
+			return nil
+		}
+		z, err := cp.c.Compress(e.body)
+		if err != nil {
+			return err
+		}
+		if len(z) >= len(e.body) {
+			return nil
+		}
+		p.compressStats.add(len(e.body), len(z))
+		e.body = z
+		e.set(HeaderContentEncoding, cp.c.Encoding())
+		return nil
+	}
+}
+
+// 解压信封消息体, 自定义的压缩算法解压后再检查大小
+func decompressEnvelope(e *envelope) error {
+	encoding := e.get(HeaderContentEncoding)
+	if encoding == "" {
+		return nil
+	}
+	c, err := GetCompressor(encoding)
+	if err != nil {
+		return err
+	}
+	body, err := c.Decompress(e.body)
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > getMaxDecompressedSize() {
+		return ErrDecompressedTooLarge
+	}
+	e.body = body
+	delete(e.headers, HeaderContentEncoding)
+	return nil
+}
+
+// 压缩统计, 只统计实际压缩的消息
+type compressStats struct {
+	count int64
+	in    int64 // 压缩前字节数
+	out   int64 // 压缩后字节数
+}
+
+func (s *compressStats) add(in, out int) {
+	atomic.AddInt64(&s.count, 1)
+	atomic.AddInt64(&s.in, int64(in))
+	atomic.AddInt64(&s.out, int64(out))
+}
+
+// compress_ratio为压缩后大小占压缩前大小的百分比
+func (s *compressStats) stats() map[string]int64 {
+	in, out := atomic.LoadInt64(&s.in), atomic.LoadInt64(&s.out)
+	var ratio int64
+	if in > 0 {
+		ratio = out * 100 / in
+	}
+	return map[string]int64{
+		"compress":           atomic.LoadInt64(&s.count),
+		"compress_in_bytes":  in,
+		"compress_out_bytes": out,
+		"compress_saved":     in - out,
+		"compress_ratio":     ratio,
+	}
+}
@@ -0,0 +1,103 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	for _, c := range []Compressor{GzipCompressor{}, SnappyCompressor{}, ZstdCompressor{}} {
+		p := NewProducer()
+		q := newBytesQueue()
+		p.AddQueue(q)
+		p.SetCompression(c, 500, "t")
+		small := GenTask("t", "m")
+		large := GenTask("t", strings.Repeat("x", 1000))
+		p.BatchEnqueueWithTask(context.Background(), "t", []Task{small, large})
+
+		for k, want := range []Task{small, large} {
+			m := q.items["t"][k]
+			e, _ := unmarshalEnvelope(m)
+			if compressed := e != nil && e.get(HeaderContentEncoding) == c.Encoding(); compressed != (k == 1) {
+				t.Fatalf("%s: message %d compressed=%v", c.Encoding(), k, compressed)
+			}
+			got, err := DecodeBytesTask(m)
+			if err != nil || got.Message != want.Message {
+				t.Fatal(c.Encoding(), err)
+			}
+		}
+		if stats := p.Stats(); stats["compress"] != 1 || stats["compress_saved"] <= 0 {
+			t.Fatal(c.Encoding(), stats)
+		}
+	}
+}
+
+// 解压后超过上限的消息返回ErrDecompressedTooLarge
+func TestDecompressLimit(t *testing.T) {
+	SetMaxDecompressedSize(1024)
+	defer SetMaxDecompressedSize(0)
+	data := bytes.Repeat([]byte{0}, 4096)
+	for _, c := range []Compressor{GzipCompressor{}, SnappyCompressor{}, ZstdCompressor{}} {
+		z, _ := c.Compress(data)
+		if _, err := c.Decompress(z); err != ErrDecompressedTooLarge {
+			t.Fatal(c.Encoding(), err)
+		}
+		z, _ = c.Compress(data[:1024])
+		if b, err := c.Decompress(z); err != nil || len(b) != 1024 {
+			t.Fatal(c.Encoding(), err)
+		}
+	}
+}
+
+// 超过上限的消息进入死信队列
+func TestDecompressLimitDeadLetter(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	j.SetCompression(GzipCompressor{}, 1, "t")
+	j.AddFunc(q, "t", func(ctx context.Context, task *Task) { t.Error("bomb executed") }, 1)
+	j.AddQueue(q)
+	j.SetDeadLetter("t", "t:dead")
+	rejected := make(chan error, 1)
+	j.RegisterMessageErrCallback(func(topic string, message []byte, err error) { rejected <- err })
+	j.Enqueue(context.Background(), "t", strings.Repeat("x", 4096))
+
+	SetMaxDecompressedSize(1024)
+	defer SetMaxDecompressedSize(0)
+	j.Start()
+	defer stopJob(t, j)
+	select {
+	case err := <-rejected:
+		if !errors.Is(err, ErrDecompressedTooLarge) {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not rejected")
+	}
+	waitFor(t, time.Second, func() bool { return q.len("t:dead") == 1 })
+}
+
+// 帧头没有声明大小的zstd流同样按上限截断
+func TestZstdStreamLimit(t *testing.T) {
+	SetMaxDecompressedSize(1024)
+	defer SetMaxDecompressedSize(0)
+	var buf bytes.Buffer
+	zw, _ := zstd.NewWriter(&buf)
+	for i := 0; i < 4; i++ {
+		zw.Write(bytes.Repeat([]byte{0}, 1024))
+		zw.Flush()
+	}
+	zw.Close()
+	var h zstd.Header
+	if err := h.Decode(buf.Bytes()); err != nil || h.HasFCS {
+		t.Fatalf("header %+v %v", h, err)
+	}
+	if _, err := (ZstdCompressor{}).Decompress(buf.Bytes()); err != ErrDecompressedTooLarge {
+		t.Fatal(err)
+	}
+}
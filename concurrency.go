@@ -0,0 +1,64 @@
+package job
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 运行时调整worker的并发数, 同时调整协程池大小和pipe缓冲大小
+// 已经在执行的任务不受影响, 协程池缩小时多余的协程在任务结束后回收
+func (w *WorkerWithFunc) SetConcurrency(n int) {
+	if n <= 0 {
+		n = defaultConcurrency
+	}
+	w.Tune(n)
+
+	pipeSize := n
+	if w.batchWorker != nil {
+		pipeSize = batchPipeSize(n, w.batchSize)
+	}
+
+	w.pipeMu.Lock()
+	defer w.pipeMu.Unlock()
+	if !w.isWorking() || cap(w.pipe) == pipeSize {
+		return
+	}
+	// 关闭旧pipe, 执行协程读完旧pipe中剩余的任务后切换到新pipe, 任务顺序不变
+	old := w.pipe
+	w.pipe = make(chan *Task, pipeSize)
+	if w.pipeNext == nil {
+		w.pipeNext = make(map[chan *Task]chan *Task)
+	}
+	w.pipeNext[old] = w.pipe
+	close(old)
+}
+
+// 获取worker的并发数
+func (w *WorkerWithFunc) Concurrency() int {
+	return w.Cap()
+}
+
+// 获取worker的运行状态统计
+func (w *WorkerWithFunc) Stats() map[string]int64 {
+	pipe := w.getPipe()
+	var paused int64
+	if w.Paused() {
+		paused = 1
+	}
+	return map[string]int64{
+		"paused":           paused,
+		"inflight":         atomic.LoadInt64(&w.inflight),
+		"concurrency":      int64(w.Cap()),
+		"running":          int64(w.Running()),
+		"pending":          int64(len(pipe)),
+		"pipe_size":        int64(cap(pipe)),
+		"throttled":        atomic.LoadInt64(&w.throttledCount),
+		"breaker_state":    int64(w.BreakerState()),
+		"breaker_rejected": atomic.LoadInt64(&w.breakerRejected),
+		"deferred":         atomic.LoadInt64(&w.deferredCount),
+		"delayed":          atomic.LoadInt64(&w.delayed),
+		"decrypt_err":      atomic.LoadInt64(&w.decryptErrCount),
+		"signature_err":    atomic.LoadInt64(&w.signatureErrCount),
+		"throttled_ms":     atomic.LoadInt64(&w.throttledTime) / int64(time.Millisecond),
+	}
+}
@@ -0,0 +1,94 @@
+package job
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 运行中调整并发数, 任务不丢失也不重复, 并发数不超过当前设置
+func TestSetConcurrency(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	var running, peak, done int64
+	limit := int64(2)
+	j.AddFunc(q, "c", func(ctx context.Context, task *Task) {
+		n := atomic.AddInt64(&running, 1)
+		if n > atomic.LoadInt64(&limit) {
+			atomic.StoreInt64(&peak, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt64(&running, -1)
+		atomic.AddInt64(&done, 1)
+	}, 2)
+	msgs := make([]string, 200)
+	for i := range msgs {
+		msgs[i] = "m"
+	}
+	j.BatchEnqueue(context.Background(), "c", msgs)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&done) >= 20 })
+	atomic.StoreInt64(&limit, 6)
+	if err := j.SetConcurrency("c", 6); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return atomic.LoadInt64(&done) >= 100 })
+	if err := j.SetConcurrency("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return atomic.LoadInt64(&done) == 200 })
+
+	if p := atomic.LoadInt64(&peak); p != 0 {
+		t.Fatalf("ran %d tasks concurrently", p)
+	}
+	stats, _ := j.TopicStats("c")
+	if stats["concurrency"] != 3 || stats["pipe_size"] != 3 {
+		t.Fatal(stats)
+	}
+	if s := j.Stats(); s["handle"] != 200 {
+		t.Fatal(s)
+	}
+	if err := j.SetConcurrency("none", 1); err != ErrWorkerNotExist {
+		t.Fatal(err)
+	}
+}
+
+// 执行协程还阻塞在旧pipe上时连续调整两次, 中间pipe中的任务不会丢失
+func TestSetConcurrencyTwice(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	release := make(chan struct{})
+	var done int64
+	j.AddFunc(q, "c", func(ctx context.Context, task *Task) {
+		<-release
+		atomic.AddInt64(&done, 1)
+	}, 1)
+	msgs := make([]string, 30)
+	for i := range msgs {
+		msgs[i] = "m"
+	}
+	j.BatchEnqueue(context.Background(), "c", msgs)
+	j.Start()
+	defer stopJob(t, j)
+
+	pending := func(n int64) func() bool {
+		return func() bool {
+			stats, _ := j.TopicStats("c")
+			return stats["pending"] == n
+		}
+	}
+	waitFor(t, 2*time.Second, pending(1))
+	j.SetConcurrency("c", 5)
+	waitFor(t, 2*time.Second, pending(5))
+	j.SetConcurrency("c", 8)
+	close(release)
+
+	waitFor(t, 5*time.Second, func() bool { return atomic.LoadInt64(&done) == 30 })
+	waitFor(t, time.Second, func() bool {
+		stats, _ := j.TopicStats("c")
+		return stats["inflight"] == 0
+	})
+}
@@ -0,0 +1,49 @@
+package job
+
+import (
+	"github.com/navi-tt/job/internal/log"
+	"github.com/navi-tt/job/internal/queue"
+)
+
+// 设置死信topic, 无法处理的消息原样转入该topic后ack, 为空时不转入直接ack丢弃
+// 死信topic使用Job的queue路由, 没有worker时需要通过AddQueue设置queue驱动
+func (w *WorkerWithFunc) SetDeadLetter(topic string) {
+	w.deadLetter.Store(topic)
+}
+
+func (w *WorkerWithFunc) DeadLetter() string {
+	topic, _ := w.deadLetter.Load().(string)
+	return topic
+}
+
+// 处理无法解密、签名不正确等无法处理的消息: 调用回调函数, 设置了死信topic时转入死信队列并ack
+// 没有设置死信topic时直接ack丢弃, 避免重放后反复拒绝; 转入失败时不ack, 如果queue服务支持, 会进行消息重放
+func (w *WorkerWithFunc) reject(m queue.Message, err error) {
+	message := m.Body
+	if message == nil {
+		message = []byte(m.Message)
+	}
+	if w.forward(message, err) || w.DeadLetter() == "" {
+		w.ack(&Task{Topic: w.Topic(), Token: m.Token})
+	}
+}
+
+// 调用回调函数并原样转入死信topic, 返回是否已转入
+func (w *WorkerWithFunc) forward(message []byte, err error) bool {
+	if f := w.Job().messageErrCallback; f != nil {
+		f(w.Topic(), message, err)
+	} else {
+		log.Errorf("reject_message: %v, %v", err, w.Topic())
+	}
+
+	dl := w.DeadLetter()
+	if dl == "" {
+		return false
+	}
+	ok, err := w.Job().producer.EnqueueRaw(w.Job().ctx, dl, string(message))
+	if err != nil || !ok {
+		log.Errorf("dead_letter_error: %v, %v", err, dl)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package job
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/navi-tt/job/internal/queue"
+)
+
+// 支持阻塞出队的队列, 记录各种出队方式的调用次数
+type blockingQueue struct {
+	*memQueue
+	blocking int64
+	polling  int64
+}
+
+func (q *blockingQueue) Dequeue(ctx context.Context, key string, args ...interface{}) (string, string, int64, error) {
+	atomic.AddInt64(&q.polling, 1)
+	return q.memQueue.Dequeue(ctx, key, args...)
+}
+
+func (q *blockingQueue) BlockingDequeue(ctx context.Context, key string, timeout time.Duration, args ...interface{}) (string, string, int64, error) {
+	atomic.AddInt64(&q.blocking, 1)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		m, token, n, err := q.memQueue.Dequeue(ctx, key, args...)
+		if err != queue.ErrNil {
+			return m, token, n, err
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return "", "", 0, queue.ErrNil
+}
+
+func TestBlockingDequeue(t *testing.T) {
+	q := &blockingQueue{memQueue: newMemQueue()}
+	j := New()
+	// 空队列退避很长, 只有使用阻塞出队才能及时拉取到任务
+	j.SetEmptyBackoffFunc(ConstantBackoff(time.Hour))
+	j.SetBlockTimeout(50 * time.Millisecond)
+	done := make(chan struct{}, 1)
+	j.AddFunc(q, "b", func(ctx context.Context, task *Task) { done <- struct{}{} }, 1)
+	j.Start()
+	defer stopJob(t, j)
+
+	time.Sleep(20 * time.Millisecond)
+	j.Enqueue(context.Background(), "b", "m")
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("task not executed")
+	}
+	if atomic.LoadInt64(&q.blocking) == 0 || atomic.LoadInt64(&q.polling) != 0 {
+		t.Fatalf("blocking=%d polling=%d", q.blocking, q.polling)
+	}
+}
+
+// 支持批量出队的队列, 记录每次请求的数量
+type batchQueue struct {
+	*memQueue
+	maxN int64
+}
+
+func (q *batchQueue) BatchDequeue(ctx context.Context, key string, n int, args ...interface{}) ([]QueueMessage, error) {
+	for {
+		old := atomic.LoadInt64(&q.maxN)
+		if int64(n) <= old || atomic.CompareAndSwapInt64(&q.maxN, old, int64(n)) {
+			break
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items[key]
+	if n > len(items) {
+		n = len(items)
+	}
+	out := make([]QueueMessage, n)
+	for i := range out {
+		out[i] = QueueMessage{Message: items[i], Token: "tok"}
+	}
+	q.items[key] = items[n:]
+	return out, nil
+}
+
+func TestBatchDequeue(t *testing.T) {
+	q := &batchQueue{memQueue: newMemQueue()}
+	j := New()
+	var count int64
+	j.AddFunc(q, "b", func(ctx context.Context, task *Task) { atomic.AddInt64(&count, 1) }, 4)
+	msgs := make([]string, 100)
+	for i := range msgs {
+		msgs[i] = "m"
+	}
+	j.BatchEnqueue(context.Background(), "b", msgs)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&count) == 100 })
+	w, _ := j.getWorker("b")
+	if n := atomic.LoadInt64(&q.maxN); n < 2 || n > int64(cap(w.getPipe())) {
+		t.Fatalf("batch size %d, pipe %d", n, cap(w.getPipe()))
+	}
+	if atomic.LoadInt64(&q.acks) != 100 {
+		t.Fatalf("acks %d", q.acks)
+	}
+}
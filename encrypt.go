@@ -0,0 +1,164 @@
+package job
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	EncryptionAESGCM = "aes-gcm"
+
+	//信封头：消息体加密算法
+	HeaderEncryption = "encryption"
+	//信封头：加密使用的密钥id
+	HeaderKeyId = "key-id"
+)
+
+var (
+	ErrKeyNotExist   = errors.New("encryption key is not exists")
+	ErrDecryptFailed = errors.New("decrypt task failed")
+)
+
+// 密钥提供者, 可以对接KMS等密钥管理服务
+// 加密时记录密钥id, 轮换密钥后旧密钥需要保留到使用旧密钥加密的消息消费完
+type KeyProvider interface {
+	// 返回topic当前用于加密的密钥, 密钥长度为16、24或32字节, 分别对应AES-128、AES-192、AES-256
+	CurrentKey(topic string) (keyId string, key []byte, err error)
+	// 按密钥id返回解密密钥, 不存在时返回ErrKeyNotExist
+	Key(keyId string) ([]byte, error)
+}
+
+// 内存密钥环, 所有topic共用当前密钥
+type Keyring struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+func NewKeyring(keyId string, key []byte) *Keyring {
+	k := &Keyring{keys: make(map[string][]byte)}
+	k.Rotate(keyId, key)
+	return k
+}
+
+// 添加密钥并设置为当前密钥, 旧密钥仍可用于解密
+func (k *Keyring) Rotate(keyId string, key []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[keyId] = key
+	k.current = keyId
+}
+
+// 删除旧密钥, 不能删除当前密钥
+func (k *Keyring) Remove(keyId string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if keyId != k.current {
+		delete(k.keys, keyId)
+	}
+}
+
+func (k *Keyring) CurrentKey(topic string) (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[k.current]
+	if !ok {
+		return "", nil, ErrKeyNotExist
+	}
+	return k.current, key, nil
+}
+
+func (k *Keyring) Key(keyId string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotExist, keyId)
+	}
+	return key, nil
+}
+
+// 返回加密信封消息体的函数, 在压缩之后执行, kp为nil时不加密
+// 密文格式: nonce(12) + AES-GCM密文, topic、密钥id、content-type和content-encoding作为附加数据
+func encrypt(topic string, kp KeyProvider) envelopeFunc {
+	return func(e *envelope) error {
+		if kp == nil {
+			return nil
+		}
+		keyId, key, err := kp.CurrentKey(topic)
+		if err != nil {
+			return err
+		}
+		aead, err := newAESGCM(key)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(e.body)+aead.Overhead())
+		if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+		e.set(HeaderEncryption, EncryptionAESGCM)
+		e.set(HeaderKeyId, keyId)
+		e.body = aead.Seal(nonce, nonce, e.body, additionalData(topic, e))
+		return nil
+	}
+}
+
+// 加密的附加数据, 修改头信息或者把消息转移到其他topic后解密失败
+func additionalData(topic string, e *envelope) []byte {
+	b := appendBytes(nil, []byte(topic))
+	b = appendBytes(b, []byte(e.get(HeaderKeyId)))
+	b = appendBytes(b, []byte(e.get(HeaderContentType)))
+	return appendBytes(b, []byte(e.get(HeaderContentEncoding)))
+}
+
+// 解密信封消息体, 失败时返回的错误包装了ErrDecryptFailed
+// kp不为nil时拒绝没有加密的消息, 防止绕过加密投递明文消息
+func decryptEnvelope(e *envelope, topic string, kp KeyProvider) error {
+	alg := e.get(HeaderEncryption)
+	if alg == "" {
+		if kp != nil {
+			return fmt.Errorf("%w: unencrypted message", ErrDecryptFailed)
+		}
+		return nil
+	}
+	if alg != EncryptionAESGCM {
+		return fmt.Errorf("%w: unknown encryption %s", ErrDecryptFailed, alg)
+	}
+	if kp == nil {
+		return fmt.Errorf("%w: key provider is not set", ErrDecryptFailed)
+	}
+	key, err := kp.Key(e.get(HeaderKeyId))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecryptFailed, err)
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecryptFailed, err)
+	}
+	if len(e.body) < aead.NonceSize() {
+		return fmt.Errorf("%w: message too short", ErrDecryptFailed)
+	}
+	nonce, ciphertext := e.body[:aead.NonceSize()], e.body[aead.NonceSize():]
+	body, err := aead.Open(nil, nonce, ciphertext, additionalData(topic, e))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecryptFailed, err)
+	}
+	e.body = body
+	delete(e.headers, HeaderEncryption)
+	delete(e.headers, HeaderKeyId)
+	return nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
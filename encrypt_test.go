@@ -0,0 +1,98 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encryptedMessage(t *testing.T, kr KeyProvider, task Task) []byte {
+	t.Helper()
+	p := NewProducer()
+	b, err := encodeTask(&task, MsgpackCodec{}, p.compress(compression{c: GzipCompressor{}, threshold: 1}), encrypt("t", kr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// 轮换密钥后旧消息仍可以解密
+func TestEncryptRoundTrip(t *testing.T) {
+	kr := NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	task := GenTask("t", strings.Repeat("secret", 100))
+	old := encryptedMessage(t, kr, task)
+	kr.Rotate("k2", bytes.Repeat([]byte{2}, 16))
+	cur := encryptedMessage(t, kr, task)
+	if bytes.Contains(old, []byte("secret")) {
+		t.Fatal("plaintext in message")
+	}
+
+	opts := decodeOptions{topic: "t", keys: kr}
+	for _, b := range [][]byte{old, cur} {
+		got, err := decodeTask(b, opts)
+		if err != nil || got.Message != task.Message {
+			t.Fatal(err)
+		}
+	}
+	if _, err := DecodeBytesTask(cur); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatal(err)
+	}
+	kr.Remove("k1")
+	if _, err := decodeTask(old, opts); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatal(err)
+	}
+}
+
+// 修改头信息、消息体或者topic后解密失败
+func TestEncryptTamper(t *testing.T) {
+	kr := NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	kr.Rotate("k2", bytes.Repeat([]byte{2}, 32))
+	b := encryptedMessage(t, kr, GenTask("t", strings.Repeat("m", 100)))
+	tampers := map[string]func(e *envelope){
+		"content-type":     func(e *envelope) { e.set(HeaderContentType, ContentTypeJSON) },
+		"content-encoding": func(e *envelope) { delete(e.headers, HeaderContentEncoding) },
+		"key-id":           func(e *envelope) { e.set(HeaderKeyId, "k1") },
+		"body":             func(e *envelope) { e.body[len(e.body)-1] ^= 1 },
+	}
+	for name, tamper := range tampers {
+		e, _ := unmarshalEnvelope(append([]byte(nil), b...))
+		tamper(e)
+		if _, err := decodeTask(e.marshal(), decodeOptions{topic: "t", keys: kr}); !errors.Is(err, ErrDecryptFailed) {
+			t.Fatal(name, err)
+		}
+	}
+	if _, err := decodeTask(b, decodeOptions{topic: "other", keys: kr}); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatal("topic", err)
+	}
+}
+
+// 设置了加密的topic拒绝明文消息
+func TestEncryptDowngrade(t *testing.T) {
+	kr := NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	task := GenTask("t", "m")
+	plain, _ := encodeTask(&task, JSONCodec{})
+	envelope, _ := encodeTask(&task, MsgpackCodec{})
+	for _, b := range [][]byte{plain, envelope} {
+		if _, err := decodeTask(b, decodeOptions{topic: "t", keys: kr}); !errors.Is(err, ErrDecryptFailed) {
+			t.Fatal(err)
+		}
+	}
+
+	// 明文消息进入死信队列, 不执行
+	q := newMemQueue()
+	j := New()
+	j.AddQueue(q)
+	j.AddFunc(q, "t", func(ctx context.Context, task *Task) { t.Error("plaintext executed") }, 1)
+	j.SetDeadLetter("t", "t:dead")
+	q.Enqueue(context.Background(), "t", string(plain))
+	j.SetEncryption(kr, "t")
+	j.Start()
+	defer stopJob(t, j)
+	waitFor(t, 2*time.Second, func() bool { return q.len("t:dead") == 1 })
+	if stats, _ := j.TopicStats("t"); stats["decrypt_err"] != 1 {
+		t.Fatal(stats)
+	}
+}
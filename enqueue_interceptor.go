@@ -0,0 +1,56 @@
+package job
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrMessageTooLarge = errors.New("message is too large")
+)
+
+// 入队处理函数, tasks的Topic已经补全
+// 返回的results与tasks一一对应, err不为nil时整批入队失败
+type EnqueueHandler func(ctx context.Context, topic string, tasks []*Task, args ...interface{}) (results []EnqueueResult, err error)
+
+// 入队拦截器, 在编码和调用队列驱动之前执行, 可以补充任务信息、校验、限制大小、统计等
+// 不调用next或者返回错误则取消入队
+type EnqueueInterceptor func(next EnqueueHandler) EnqueueHandler
+
+// 组合拦截器, 第一个拦截器在最外层
+func chainEnqueue(h EnqueueHandler, interceptors ...EnqueueInterceptor) EnqueueHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		h = interceptors[i](h)
+	}
+	return h
+}
+
+type maxMessageSizeKey struct{}
+
+// 限制最终入队的消息大小, 即经过claim-check、编码、压缩、加密和签名之后的字节数
+// 超过size字节返回ErrMessageTooLarge, 整批都不会入队, 多次设置时以最小的为准
+func MaxMessageSizeInterceptor(size int) EnqueueInterceptor {
+	return func(next EnqueueHandler) EnqueueHandler {
+		return func(ctx context.Context, topic string, tasks []*Task, args ...interface{}) ([]EnqueueResult, error) {
+			// 拦截器在编码之前执行, 把限制传给编码之后的检查
+			if max, ok := ctx.Value(maxMessageSizeKey{}).(int); !ok || size < max {
+				ctx = context.WithValue(ctx, maxMessageSizeKey{}, size)
+			}
+			return next(ctx, topic, tasks, args...)
+		}
+	}
+}
+
+// 检查编码后的消息大小
+func checkMessageSize(ctx context.Context, messages [][]byte) error {
+	max, ok := ctx.Value(maxMessageSizeKey{}).(int)
+	if !ok {
+		return nil
+	}
+	for _, m := range messages {
+		if len(m) > max {
+			return ErrMessageTooLarge
+		}
+	}
+	return nil
+}
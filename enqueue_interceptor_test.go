@@ -0,0 +1,105 @@
+package job
+
+import (
+	"context"
+	"crypto/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 拦截器按添加顺序由外到内, 可以修改任务
+func TestEnqueueInterceptorOrder(t *testing.T) {
+	q := newMemQueue()
+	p := NewProducer()
+	p.AddQueue(q)
+	var calls []string
+	record := func(name string) EnqueueInterceptor {
+		return func(next EnqueueHandler) EnqueueHandler {
+			return func(ctx context.Context, topic string, tasks []*Task, args ...interface{}) ([]EnqueueResult, error) {
+				calls = append(calls, name)
+				for _, task := range tasks {
+					task.SetHeader("by", name)
+				}
+				return next(ctx, topic, tasks, args...)
+			}
+		}
+	}
+	p.Use(record("a"), record("b"))
+	if ok, err := p.Enqueue(context.Background(), "t", "m"); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if !reflect.DeepEqual(calls, []string{"a", "b"}) {
+		t.Fatal(calls)
+	}
+	task, _ := DecodeStringTask(q.messages("t")[0])
+	if task.GetHeader("by") != "b" {
+		t.Fatal(task.Headers)
+	}
+}
+
+// 拦截器不调用next或者过滤掉任务时返回错误, 不会panic
+func TestEnqueueInterceptorFilter(t *testing.T) {
+	q := newMemQueue()
+	p := NewProducer()
+	p.AddQueue(q)
+	p.Use(func(next EnqueueHandler) EnqueueHandler {
+		return func(ctx context.Context, topic string, tasks []*Task, args ...interface{}) ([]EnqueueResult, error) {
+			return next(ctx, topic, nil, args...)
+		}
+	})
+	if _, err := p.EnqueueWithResult(context.Background(), "t", GenTask("t", "m")); err != ErrEnqueueFailed {
+		t.Fatal(err)
+	}
+
+	p = NewProducer()
+	p.AddQueue(q)
+	p.Use(func(next EnqueueHandler) EnqueueHandler {
+		return func(ctx context.Context, topic string, tasks []*Task, args ...interface{}) ([]EnqueueResult, error) {
+			return nil, nil
+		}
+	})
+	if ok, err := p.Enqueue(context.Background(), "t", "m"); ok || err != ErrEnqueueFailed {
+		t.Fatal(ok, err)
+	}
+	if q.len("t") != 0 {
+		t.Fatal("filtered task enqueued")
+	}
+}
+
+// 按最终入队的字节数限制, 压缩和claim-check之后变小的消息可以入队
+func TestMaxMessageSizeInterceptor(t *testing.T) {
+	q := newMemQueue()
+	p := NewProducer()
+	p.AddQueue(q)
+	p.Use(MaxMessageSizeInterceptor(2048), MaxMessageSizeInterceptor(4096))
+	p.SetCompression(GzipCompressor{}, 0, "zip")
+	store, err := NewFileClaimStore(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetClaimCheck(store, 1024, "claim")
+	ctx := context.Background()
+
+	compressible := strings.Repeat("a", 10*1024)
+	if _, err := p.Enqueue(ctx, "plain", compressible); err != ErrMessageTooLarge {
+		t.Fatalf("plain: %v", err)
+	}
+	if _, err := p.Enqueue(ctx, "zip", compressible); err != nil {
+		t.Fatalf("compressed: %v", err)
+	}
+	random := make([]byte, 3*1024)
+	rand.Read(random)
+	if _, err := p.EnqueueBytes(ctx, "zip", random); err != ErrMessageTooLarge {
+		t.Fatalf("incompressible over the smaller limit: %v", err)
+	}
+	if _, err := p.EnqueueBytes(ctx, "claim", random); err != nil {
+		t.Fatalf("claim-checked: %v", err)
+	}
+
+	// 整批都不入队
+	_, err = p.BatchEnqueue(ctx, "plain", []string{"small", compressible})
+	if err != ErrMessageTooLarge || q.len("plain") != 0 {
+		t.Fatalf("batch: %v, %d enqueued", err, q.len("plain"))
+	}
+}
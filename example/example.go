@@ -226,3 +226,18 @@ func (q *LocalQueue) Dequeue(ctx context.Context, key string, args ...interface{
 func (q *LocalQueue) AckMsg(ctx context.Context, key string, token string, args ...interface{}) (bool, error) {
 	return true, nil
 }
+
+func (q *LocalQueue) BatchDequeue(ctx context.Context, key string, n int, args ...interface{}) (messages []queue.Message, err error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if n > len(queues[key]) {
+		n = len(queues[key])
+	}
+	messages = make([]queue.Message, n)
+	for i := 0; i < n; i++ {
+		messages[i] = queue.Message{Message: queues[key][i]}
+	}
+	queues[key] = queues[key][n:]
+	return messages, nil
+}
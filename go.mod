@@ -3,6 +3,10 @@ module github.com/navi-tt/job
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.4
+	github.com/golang/snappy v0.0.4
+	github.com/gomodule/redigo v1.8.5
 	github.com/google/uuid v1.1.1
+	github.com/klauspost/compress v1.12.3
 	github.com/panjf2000/ants/v2 v2.4.1
 )
@@ -0,0 +1,99 @@
+package job
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/navi-tt/job/internal/queue"
+)
+
+// 测试用内存队列, 出队即删除, 不会重放
+type memQueue struct {
+	mu    sync.Mutex
+	items map[string][]string
+	acks  int64
+	acked []string
+}
+
+func newMemQueue() *memQueue {
+	return &memQueue{items: make(map[string][]string)}
+}
+
+func (q *memQueue) Enqueue(ctx context.Context, key string, message string, args ...interface{}) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items[key] = append(q.items[key], message)
+	return true, nil
+}
+
+func (q *memQueue) BatchEnqueue(ctx context.Context, key string, messages []string, args ...interface{}) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items[key] = append(q.items[key], messages...)
+	return true, nil
+}
+
+func (q *memQueue) Dequeue(ctx context.Context, key string, args ...interface{}) (string, string, int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items[key]) == 0 {
+		return "", "", 0, queue.ErrNil
+	}
+	m := q.items[key][0]
+	q.items[key] = q.items[key][1:]
+	return m, "tok", 1, nil
+}
+
+func (q *memQueue) AckMsg(ctx context.Context, key string, token string, args ...interface{}) (bool, error) {
+	atomic.AddInt64(&q.acks, 1)
+	return true, nil
+}
+
+func (q *memQueue) len(key string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items[key])
+}
+
+func (q *memQueue) messages(key string) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string(nil), q.items[key]...)
+}
+
+// 在timeout内等待cond成立
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// 停止Job并等待任务完成
+func stopJob(t *testing.T, j *Job) {
+	t.Helper()
+	j.Stop()
+	if err := j.WaitStop(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 创建临时目录, 测试结束后删除
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "job-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
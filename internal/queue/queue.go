@@ -3,15 +3,72 @@ package queue
 import (
 	"context"
 	"errors"
+	"time"
 )
 
+// 模块外的驱动使用job.ErrQueueNil
 var (
 	ErrNil = errors.New("return nil")
 )
 
+// 出队的一条消息, 模块外的驱动使用job.QueueMessage
+type Message struct {
+	Message      string
+	Body         []byte // 二进制消息, 驱动实现BytesQueue时设置, 不为nil时优先于Message
+	Token        string
+	DequeueCount int64
+}
+
 type Queue interface {
 	Enqueue(ctx context.Context, key string, message string, args ...interface{}) (isOk bool, err error)
 	Dequeue(ctx context.Context, key string, args ...interface{}) (message string, token string, dequeueCount int64, err error)
 	AckMsg(ctx context.Context, key string, token string, args ...interface{}) (ok bool, err error)
 	BatchEnqueue(ctx context.Context, key string, messages []string, args ...interface{}) (isOk bool, err error)
 }
+
+// 可选能力: 阻塞出队, 队列为空时最多等待timeout, 超时返回ErrNil
+// 如 BRPOPLPUSH, SQS WaitTimeSeconds, JetStream fetch 等
+type BlockingQueue interface {
+	Queue
+	BlockingDequeue(ctx context.Context, key string, timeout time.Duration, args ...interface{}) (message string, token string, dequeueCount int64, err error)
+}
+
+// 可选能力: 批量出队, 一次最多返回n条消息, 队列为空时返回空切片或ErrNil
+type BatchQueue interface {
+	Queue
+	BatchDequeue(ctx context.Context, key string, n int, args ...interface{}) (messages []Message, err error)
+}
+
+// 可选能力: 批量ack
+type BatchAckQueue interface {
+	Queue
+	BatchAckMsg(ctx context.Context, key string, tokens []string, args ...interface{}) (ok bool, err error)
+}
+
+// 驱动返回的单条消息入队结果, 模块外的驱动使用job.QueueSendResult
+// 与job.EnqueueResult区分, 后者带有任务id
+type SendResult struct {
+	MessageId string // 队列服务生成的消息id
+	Err       error
+}
+
+// 可选能力: 入队时返回队列服务生成的消息id
+type MessageIdQueue interface {
+	Queue
+	EnqueueWithId(ctx context.Context, key string, message string, args ...interface{}) (messageId string, err error)
+}
+
+// 可选能力: 批量入队时返回每条消息的结果, results与messages一一对应
+type BatchResultQueue interface {
+	Queue
+	BatchEnqueueWithResult(ctx context.Context, key string, messages []string, args ...interface{}) (results []SendResult, err error)
+}
+
+// 可选能力: 二进制消息, 出入队不经过string转换
+// DequeueBytes返回的message在任务执行完成前不能被驱动复用, 驱动可以直接返回读取到的缓冲区
+type BytesQueue interface {
+	Queue
+	EnqueueBytes(ctx context.Context, key string, message []byte, args ...interface{}) (isOk bool, err error)
+	DequeueBytes(ctx context.Context, key string, args ...interface{}) (message []byte, token string, dequeueCount int64, err error)
+	BatchEnqueueBytes(ctx context.Context, key string, messages [][]byte, args ...interface{}) (isOk bool, err error)
+}
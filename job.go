@@ -10,12 +10,15 @@ import (
 const (
 	//默认worker的并发数
 	defaultConcurrency = 5
+	//默认每个worker本地最多保留的未到最早执行时间的任务数
+	defaultDelayBuffer = 1000
 )
 
 var (
 	ErrQueueNotExist   = errors.New("queue is not exists")
 	ErrTimeout         = errors.New("timeout")
 	ErrTopicRegistered = errors.New("the key had been registered")
+	ErrWorkerNotExist  = errors.New("worker is not exists")
 )
 
 type Job struct {
@@ -25,19 +28,28 @@ type Job struct {
 	stopOnce sync.Once
 
 	workers map[string]*WorkerWithFunc
+	//保护workers的注册和移除，以及启动状态的切换
+	workersMu sync.RWMutex
 
 	//work并发处理的等待暂停
 	wg sync.WaitGroup
 	//启动状态
-	running bool
-	//异常状态时需要sleep时间
-	sleepy time.Duration
-	//设置的初始等待时间
-	initSleepy time.Duration
-	//设置的等待时间的上限
-	maxSleepy time.Duration
+	running int32
+	//队列为空时的退避策略，每个worker各自生成实例
+	emptyBackoff NewBackoffFunc
+	//拉取或解析异常时的退避策略，每个worker各自生成实例
+	errBackoff NewBackoffFunc
+	//兼容旧接口Sleep/ResetSleep的退避实例，按emptyBackoff生成
+	sleep   Backoff
+	sleepMu sync.Mutex
 	//通道定时器超时时间
 	timer time.Duration
+	//阻塞出队的等待时间，队列支持BlockingQueue时生效，<=0则不使用阻塞出队
+	blockTimeout time.Duration
+
+	//批量ack的数量和刷新间隔，队列支持BatchAckQueue且数量大于1时生效
+	ackBatchSize int
+	ackInterval  time.Duration
 
 	//是否初始化
 	isQueueInit bool
@@ -51,6 +63,8 @@ type Job struct {
 	handleCount      int64
 	handleErrCount   int64
 	handlePanicCount int64
+	ackCount         int64
+	ackErrCount      int64
 
 	//回调函数
 	//任务返回失败回调函数
@@ -61,6 +75,19 @@ type Job struct {
 	taskBeforeCallback func(task *Task)
 	//任务处理后回调
 	taskAfterCallback func(task *Task)
+	//任务ack失败回调
+	ackErrCallback func(task *Task, err error)
+	//无法处理的消息回调，如解密失败
+	messageErrCallback func(topic string, message []byte, err error)
+	//全局任务执行中间件
+	middlewares []Middleware
+	mwMu        sync.RWMutex
+
+	//消息入队
+	producer *Producer
+
+	//熔断状态变化回调
+	breakerCallback func(topic string, from, to BreakerState)
 }
 
 func (j *Job) processJob() {
@@ -71,19 +98,36 @@ func (j *Job) processJob() {
 
 //After there is no data, the job starts from initsleepy to sleep,
 //and then multiplies to maxsleepy. After finding the data, it sleep from initsleepy again
+//
+//Deprecated: 每个worker已经使用各自的退避实例，见SetEmptyBackoffFunc，保留用于兼容
 func (j *Job) Sleep() {
-	if j.sleepy.Nanoseconds()*2 < j.maxSleepy.Nanoseconds() {
-		j.sleepy = time.Duration(j.sleepy.Nanoseconds() * 2)
-	} else if j.sleepy.Nanoseconds()*2 >= j.maxSleepy.Nanoseconds() && j.sleepy != j.maxSleepy {
-		if j.sleepy < j.maxSleepy {
-			j.sleepy = j.maxSleepy
-		}
+	j.sleepMu.Lock()
+	if j.sleep == nil {
+		j.sleep = j.emptyBackoff()
 	}
-	time.Sleep(j.sleepy)
+	d := j.sleep.Next()
+	j.sleepMu.Unlock()
+	time.Sleep(d)
 }
 
+//Deprecated: 见Sleep
 func (j *Job) ResetSleep() {
-	if j.sleepy != j.initSleepy {
-		j.SetSleepy(j.initSleepy)
+	j.sleepMu.Lock()
+	if j.sleep != nil {
+		j.sleep.Reset()
 	}
+	j.sleepMu.Unlock()
+}
+
+func (j *Job) getMiddlewares() []Middleware {
+	j.mwMu.RLock()
+	defer j.mwMu.RUnlock()
+	return j.middlewares
+}
+
+func (j *Job) getWorker(topic string) (*WorkerWithFunc, bool) {
+	j.workersMu.RLock()
+	defer j.workersMu.RUnlock()
+	w, ok := j.workers[topic]
+	return w, ok
 }
@@ -3,6 +3,7 @@ package job
 import (
 	"context"
 	"github.com/navi-tt/job/internal/queue"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,19 +11,22 @@ func New() *Job {
 	j := new(Job)
 	j.ctx = context.Background()
 	j.workers = make(map[string]*WorkerWithFunc)
+	j.producer = NewProducer()
+	j.producer.lookup = j.workerQueue
 
-	j.sleepy = time.Millisecond * 10
-	j.initSleepy = time.Millisecond * 10
-	j.maxSleepy = time.Millisecond * 10
+	j.emptyBackoff = ExponentialBackoff(time.Millisecond*10, time.Millisecond*10)
+	j.errBackoff = ExponentialBackoff(time.Millisecond*10, time.Millisecond*10)
 	j.timer = time.Millisecond * 30
+	j.blockTimeout = time.Second
 	return j
 }
 
 func (j *Job) Start() {
-	if j.running {
+	j.workersMu.Lock()
+	defer j.workersMu.Unlock()
+	if !atomic.CompareAndSwapInt32(&j.running, 0, 1) {
 		return
 	}
-	j.running = true
 	j.processJob()
 }
 
@@ -30,7 +34,12 @@ func (j *Job) Start() {
  * 暂停Job
  */
 func (j *Job) Stop() {
-	j.running = false
+	atomic.StoreInt32(&j.running, 0)
+}
+
+//是否运行中
+func (j *Job) IsRunning() bool {
+	return atomic.LoadInt32(&j.running) == 1
 }
 
 /**
@@ -41,7 +50,8 @@ func (j *Job) WaitStop(timeout time.Duration) error {
 	var err error
 	j.stopOnce.Do(func() {
 		ch := make(chan struct{})
-		j.Sleep()
+		//等待拉取协程感知到停止状态
+		time.Sleep(j.timer)
 		if timeout <= 0 {
 			timeout = time.Second * 10
 		}
@@ -70,36 +80,192 @@ func (j *Job) AddFunc(q queue.Queue, topic string, f func(context.Context, *Task
 	return j.AddWorkerWithFunc(wp)
 }
 
+//注册批量处理worker：每次Exec处理最多batchSize个任务，凑不满时最多等待linger
+func (j *Job) AddBatchFunc(q queue.Queue, topic string, f func(context.Context, []*Task), size int, batchSize int, linger time.Duration, args ...interface{}) error {
+	wp, err := j.NewBatchWorkerWithFunc(q, topic, f, size, batchSize, linger, args)
+	if err != nil {
+		return err
+	}
+	return j.AddWorkerWithFunc(wp)
+}
+
+//注册worker，可以在任意时刻并发调用，Job已经启动时立即启动该worker
 func (j *Job) AddWorkerWithFunc(w *WorkerWithFunc) error {
+	j.workersMu.Lock()
+	defer j.workersMu.Unlock()
 	if _, ok := j.workers[w.Topic()]; ok {
 		return ErrTopicRegistered
 	}
 	j.workers[w.Topic()] = w
+	if j.IsRunning() {
+		w.Run()
+	}
+	return nil
+}
+
+//移除topic对应的worker：停止拉取新任务，等待已拉取的任务执行完成后关闭
+//超时返回ErrTimeout，此时worker仍然会被关闭，未执行的任务不会ack
+//移除后可以立即重新注册同一个topic，新旧worker不会相互影响
+func (j *Job) RemoveWorker(topic string, timeout time.Duration) error {
+	j.workersMu.Lock()
+	w, ok := j.workers[topic]
+	if !ok {
+		j.workersMu.Unlock()
+		return ErrWorkerNotExist
+	}
+	delete(j.workers, topic)
+	j.workersMu.Unlock()
+
+	err := w.Drain(timeout)
+	w.Close()
+	w.Release()
+	return err
+}
+
+//暂停topic拉取新任务，已经拉取的任务会继续执行
+func (j *Job) Pause(topic string) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	w.Pause()
+	return nil
+}
+
+//恢复topic拉取任务
+func (j *Job) Resume(topic string) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	w.Resume()
 	return nil
 }
 
+//暂停topic拉取新任务，并等待已经拉取的任务执行完成，超时返回ErrTimeout
+func (j *Job) Drain(topic string, timeout time.Duration) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	return w.Drain(timeout)
+}
+
 //获取统计数据
 func (j *Job) Stats() map[string]int64 {
-	return map[string]int64{
-		"pull":         j.pullCount,
-		"pull_err":     j.pullErrCount,
-		"pull_empty":   j.pullEmptyCount,
-		"task":         j.taskCount,
-		"task_err":     j.taskErrCount,
-		"handle":       j.handleCount,
-		"handle_err":   j.handleErrCount,
-		"handle_panic": j.handlePanicCount,
+	stats := map[string]int64{
+		"pull":         atomic.LoadInt64(&j.pullCount),
+		"pull_err":     atomic.LoadInt64(&j.pullErrCount),
+		"pull_empty":   atomic.LoadInt64(&j.pullEmptyCount),
+		"task":         atomic.LoadInt64(&j.taskCount),
+		"task_err":     atomic.LoadInt64(&j.taskErrCount),
+		"handle":       atomic.LoadInt64(&j.handleCount),
+		"handle_err":   atomic.LoadInt64(&j.handleErrCount),
+		"handle_panic": atomic.LoadInt64(&j.handlePanicCount),
+		"ack":          atomic.LoadInt64(&j.ackCount),
+		"ack_err":      atomic.LoadInt64(&j.ackErrCount),
+	}
+	//入队压缩统计
+	for k, v := range j.producer.Stats() {
+		stats[k] = v
+	}
+	return stats
+}
+
+//获取topic对应worker的运行状态统计
+func (j *Job) TopicStats(topic string) (map[string]int64, error) {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return nil, ErrWorkerNotExist
+	}
+	return w.Stats(), nil
+}
+
+//设置topic的死信topic，无法处理的消息原样转入死信topic后ack，为空时不转入直接ack丢弃
+func (j *Job) SetDeadLetter(topic string, deadLetterTopic string) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	w.SetDeadLetter(deadLetterTopic)
+	return nil
+}
+
+//运行时调整topic对应worker的并发数
+func (j *Job) SetConcurrency(topic string, n int) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	w.SetConcurrency(n)
+	return nil
+}
+
+//设置topic的令牌桶限流：每秒最多rate个任务，允许突发burst个，rate<=0取消限流
+func (j *Job) SetRateLimit(topic string, rate float64, burst int) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
 	}
+	w.SetRateLimit(rate, burst)
+	return nil
+}
+
+//设置topic的自定义限流器，传nil取消限流
+func (j *Job) SetRateLimiter(topic string, l Limiter) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	w.SetRateLimiter(l)
+	return nil
+}
+
+//设置topic的自适应并发控制，根据任务耗时和失败率调整协程池大小，传nil取消
+func (j *Job) SetConcurrencyLimiter(topic string, c ConcurrencyLimiter) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	w.SetConcurrencyLimiter(c)
+	return nil
+}
+
+//设置topic的熔断：窗口内失败率过高时停止拉取，超时后半开放行探测任务，传nil取消
+func (j *Job) SetCircuitBreaker(topic string, cfg *BreakerConfig) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	w.SetCircuitBreaker(cfg)
+	return nil
 }
 
-//设置休眠的时间 -- 碰到异常或者空消息等情况
+//设置休眠的时间 -- 碰到异常或者空消息等情况，从sleepy开始翻倍直到上限
+//空消息和异常共用同一组参数，需要分开配置请使用SetEmptyBackoffFunc/SetErrorBackoffFunc
 func (j *Job) SetSleepy(sleepy time.Duration, args ...time.Duration) {
-	j.sleepy = sleepy
-	j.initSleepy = sleepy
+	maxSleepy := sleepy
 	if len(args) > 0 {
-		j.maxSleepy = args[0]
-	} else {
-		j.maxSleepy = sleepy
+		maxSleepy = args[0]
+	}
+	j.emptyBackoff = ExponentialBackoff(sleepy, maxSleepy)
+	j.errBackoff = ExponentialBackoff(sleepy, maxSleepy)
+	j.sleepMu.Lock()
+	j.sleep = nil
+	j.sleepMu.Unlock()
+}
+
+//设置队列为空时的退避策略构造函数，每个之后启动的worker生成自己的实例
+func (j *Job) SetEmptyBackoffFunc(f NewBackoffFunc) {
+	if f != nil {
+		j.emptyBackoff = f
+	}
+}
+
+//设置拉取或解析异常时的退避策略构造函数，每个之后启动的worker生成自己的实例
+func (j *Job) SetErrorBackoffFunc(f NewBackoffFunc) {
+	if f != nil {
+		j.errBackoff = f
 	}
 }
 
@@ -108,6 +274,51 @@ func (j *Job) SetTimer(timer time.Duration) {
 	j.timer = timer
 }
 
+//设置阻塞出队的等待时间，仅对实现了queue.BlockingQueue的队列生效
+//timeout<=0时关闭阻塞出队，退回到休眠轮询
+func (j *Job) SetBlockTimeout(timeout time.Duration) {
+	j.blockTimeout = timeout
+}
+
+//设置批量ack，仅对实现了queue.BatchAckQueue的队列生效，对之后启动的worker生效
+//累计size个任务或者距离上次刷新超过interval时提交一次，size<=1时关闭批量ack
+func (j *Job) SetAckBatch(size int, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Millisecond * 100
+	}
+	j.ackBatchSize = size
+	j.ackInterval = interval
+}
+
+//添加全局任务执行中间件，对所有topic生效，在topic中间件之外执行，按添加顺序由外到内
+func (j *Job) Use(mws ...Middleware) {
+	j.mwMu.Lock()
+	j.middlewares = append(j.middlewares[:len(j.middlewares):len(j.middlewares)], mws...)
+	j.mwMu.Unlock()
+
+	j.workersMu.RLock()
+	defer j.workersMu.RUnlock()
+	for _, w := range j.workers {
+		w.buildHandler()
+	}
+}
+
+//添加入队拦截器，Enqueue/EnqueueWithTask/BatchEnqueue/BatchEnqueueWithTask在编码和调用队列驱动前经过拦截器
+//按添加顺序由外到内，EnqueueRaw不经过拦截器
+func (j *Job) UseEnqueue(interceptors ...EnqueueInterceptor) {
+	j.producer.Use(interceptors...)
+}
+
+//添加topic的任务执行中间件
+func (j *Job) UseTopic(topic string, mws ...Middleware) error {
+	w, ok := j.getWorker(topic)
+	if !ok {
+		return ErrWorkerNotExist
+	}
+	w.Use(mws...)
+	return nil
+}
+
 //设置任务处理前回调函数
 func (j *Job) RegisterTaskBeforeCallback(f func(task *Task)) {
 	j.taskBeforeCallback = f
@@ -122,3 +333,18 @@ func (j *Job) RegisterTaskAfterCallback(f func(task *Task)) {
 func (j *Job) RegisterTaskPanicCallback(f func(task *Task, e ...interface{})) {
 	j.taskPanicCallback = f
 }
+
+//设置任务ack失败回调函数，未设置时只记录日志
+func (j *Job) RegisterAckErrCallback(f func(task *Task, err error)) {
+	j.ackErrCallback = f
+}
+
+//设置无法处理的消息回调函数，如解密失败、签名不正确，message为出队的原始消息，未设置时只记录日志
+func (j *Job) RegisterMessageErrCallback(f func(topic string, message []byte, err error)) {
+	j.messageErrCallback = f
+}
+
+//设置熔断状态变化回调函数
+func (j *Job) RegisterBreakerCallback(f func(topic string, from, to BreakerState)) {
+	j.breakerCallback = f
+}
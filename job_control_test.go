@@ -0,0 +1,60 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 启动后并发注册和移除worker
+func TestAddRemoveWorkerAfterStart(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	j.Start()
+	defer stopJob(t, j)
+
+	var done int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			topic := fmt.Sprintf("t%d", i)
+			if err := j.AddFunc(q, topic, func(ctx context.Context, task *Task) { atomic.AddInt64(&done, 1) }, 1); err != nil {
+				t.Error(err)
+			}
+			j.Enqueue(context.Background(), topic, "m")
+		}(i)
+	}
+	wg.Wait()
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&done) == 10 })
+
+	if err := j.AddFunc(q, "t0", func(ctx context.Context, task *Task) {}, 1); err != ErrTopicRegistered {
+		t.Fatalf("duplicate topic: %v", err)
+	}
+	if err := j.RemoveWorker("t0", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.RemoveWorker("t0", time.Second); err != ErrWorkerNotExist {
+		t.Fatal(err)
+	}
+
+	// 移除后不再拉取, 重新注册后新worker接着处理, 没有worker时Job不再路由该topic, 直接写入队列
+	q.Enqueue(context.Background(), "t0", GenTask("t0", "m").String())
+	time.Sleep(50 * time.Millisecond)
+	if q.len("t0") != 1 {
+		t.Fatal("removed worker still pulling")
+	}
+	got := make(chan struct{}, 1)
+	if err := j.AddFunc(q, "t0", func(ctx context.Context, task *Task) { got <- struct{}{} }, 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("re-registered worker not running")
+	}
+}
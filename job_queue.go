@@ -5,9 +5,59 @@ import (
 	"github.com/navi-tt/job/internal/queue"
 )
 
-//获取topic对应的queue服务
+//队列驱动在模块外实现可选能力时使用的类型，internal/queue不能被其他模块引用
+type QueueMessage = queue.Message
+
+//驱动返回的单条消息入队结果，实现批量入队返回单条结果时使用
+type QueueSendResult = queue.SendResult
+
+//队列为空或者阻塞出队超时时驱动返回的错误
+var ErrQueueNil = queue.ErrNil
+
+//获取topic对应的queue服务，优先使用worker的queue，其次使用Producer()中设置的queue
 func (j *Job) GetQueueByTopic(topic string) queue.Queue {
-	w, ok := j.workers[topic]
+	return j.producer.GetQueueByTopic(topic)
+}
+
+//获取Job的生产者，可以为没有worker的topic设置queue驱动
+func (j *Job) Producer() *Producer {
+	return j.producer
+}
+
+//设置topic对应的queue驱动，用于只发布不消费的topic，不传topic时设置为默认queue驱动
+func (j *Job) AddQueue(q queue.Queue, topics ...string) {
+	j.producer.AddQueue(q, topics...)
+}
+
+//设置topic入队时的编解码器，不传topic时设置为默认编解码器，消费端根据消息自动选择
+func (j *Job) SetCodec(c Codec, topics ...string) {
+	j.producer.SetCodec(c, topics...)
+}
+
+//设置topic入队时的压缩算法，编码后超过threshold字节才压缩，不传topic时设置为默认压缩配置，消费端自动解压
+func (j *Job) SetCompression(c Compressor, threshold int, topics ...string) {
+	j.producer.SetCompression(c, threshold, topics...)
+}
+
+//设置topic的加密密钥，入队时加密，消费时按消息中的密钥id解密，不传topic时设置为默认密钥
+//无法解密的消息会调用RegisterMessageErrCallback设置的回调函数，设置了死信topic时转入死信队列
+func (j *Job) SetEncryption(kp KeyProvider, topics ...string) {
+	j.producer.SetEncryption(kp, topics...)
+}
+
+//设置topic的HMAC签名密钥，入队时签名，消费时在执行前验签，不传topic时设置为默认签名密钥
+//没有签名或签名不正确的消息不会执行，按无法处理的消息处理
+func (j *Job) SetSigning(keys *HMACKeys, topics ...string) {
+	j.producer.SetSigning(keys, topics...)
+}
+
+//设置topic的大消息存储，消息超过threshold字节时存入store，队列中只传递引用，执行前取回，ack成功后删除
+func (j *Job) SetClaimCheck(store ClaimStore, threshold int, topics ...string) {
+	j.producer.SetClaimCheck(store, threshold, topics...)
+}
+
+func (j *Job) workerQueue(topic string) queue.Queue {
+	w, ok := j.getWorker(topic)
 	if !ok {
 		return nil
 	}
@@ -16,57 +66,45 @@ func (j *Job) GetQueueByTopic(topic string) queue.Queue {
 
 //消息入队 -- 原始message
 func (j *Job) Enqueue(ctx context.Context, topic string, message string, args ...interface{}) (bool, error) {
-	task := GenTask(topic, message)
-	return j.EnqueueWithTask(ctx, topic, task, args...)
+	return j.producer.Enqueue(ctx, topic, message, args...)
+}
+
+//消息入队 -- 二进制消息
+func (j *Job) EnqueueBytes(ctx context.Context, topic string, payload []byte, args ...interface{}) (bool, error) {
+	return j.producer.EnqueueBytes(ctx, topic, payload, args...)
 }
 
 //消息入队 -- Task数据结构
 func (j *Job) EnqueueWithTask(ctx context.Context, topic string, task Task, args ...interface{}) (bool, error) {
-	q := j.GetQueueByTopic(topic)
-	if q == nil {
-		return false, ErrQueueNotExist
-	}
+	return j.producer.EnqueueWithTask(ctx, topic, task, args...)
+}
 
-	if task.Topic == "" {
-		task.Topic = topic
-	}
-	s, _ := JsonEncode(task)
-	return q.Enqueue(ctx, topic, s, args...)
+//消息入队 -- Task数据结构，返回任务id和队列服务生成的消息id
+func (j *Job) EnqueueWithResult(ctx context.Context, topic string, task Task, args ...interface{}) (EnqueueResult, error) {
+	return j.producer.EnqueueWithResult(ctx, topic, task, args...)
 }
 
 //消息入队 -- 原始message不带有task结构原生消息
 func (j *Job) EnqueueRaw(ctx context.Context, topic string, message string, args ...interface{}) (bool, error) {
-	q := j.GetQueueByTopic(topic)
-	if q == nil {
-		return false, ErrQueueNotExist
-	}
-
-	return q.Enqueue(ctx, topic, message, args...)
+	return j.producer.EnqueueRaw(ctx, topic, message, args...)
 }
 
 //消息入队 -- 原始message
 func (j *Job) BatchEnqueue(ctx context.Context, topic string, messages []string, args ...interface{}) (bool, error) {
-	tasks := make([]Task, len(messages))
-	for k, message := range messages {
-		tasks[k] = GenTask(topic, message)
-	}
-	return j.BatchEnqueueWithTask(ctx, topic, tasks, args...)
+	return j.producer.BatchEnqueue(ctx, topic, messages, args...)
+}
+
+//消息入队 -- 二进制消息
+func (j *Job) BatchEnqueueBytes(ctx context.Context, topic string, payloads [][]byte, args ...interface{}) (bool, error) {
+	return j.producer.BatchEnqueueBytes(ctx, topic, payloads, args...)
 }
 
 //消息入队 -- Task数据结构
 func (j *Job) BatchEnqueueWithTask(ctx context.Context, topic string, tasks []Task, args ...interface{}) (bool, error) {
-	q := j.GetQueueByTopic(topic)
-	if q == nil {
-		return false, ErrQueueNotExist
-	}
+	return j.producer.BatchEnqueueWithTask(ctx, topic, tasks, args...)
+}
 
-	arr := make([]string, len(tasks))
-	for k, task := range tasks {
-		if task.Topic == "" {
-			task.Topic = topic
-		}
-		s, _ := JsonEncode(task)
-		arr[k] = s
-	}
-	return q.BatchEnqueue(ctx, topic, arr, args...)
+//消息入队 -- Task数据结构，返回每个任务的结果，与tasks一一对应
+func (j *Job) BatchEnqueueWithResult(ctx context.Context, topic string, tasks []Task, args ...interface{}) ([]EnqueueResult, error) {
+	return j.producer.BatchEnqueueWithResult(ctx, topic, tasks, args...)
 }
@@ -0,0 +1,80 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 任务执行中间件, 包装Worker的Exec, 用于链路追踪、监控、超时、恢复、幂等等
+type Middleware func(next Worker) Worker
+
+// 组合中间件, 第一个中间件在最外层
+func chain(w Worker, mws ...Middleware) Worker {
+	for i := len(mws) - 1; i >= 0; i-- {
+		w = mws[i](w)
+	}
+	return w
+}
+
+// 添加worker的中间件, 在Job全局中间件之内执行, 按添加顺序由外到内
+// 只对单任务worker生效, 批量处理worker不经过中间件
+func (w *WorkerWithFunc) Use(mws ...Middleware) {
+	w.mwMu.Lock()
+	w.middlewares = append(w.middlewares, mws...)
+	w.mwMu.Unlock()
+	w.buildHandler()
+}
+
+// 重新组合中间件和任务执行器
+func (w *WorkerWithFunc) buildHandler() {
+	if w.worker == nil {
+		return
+	}
+	global := w.Job().getMiddlewares()
+	w.mwMu.Lock()
+	mws := make([]Middleware, 0, len(global)+len(w.middlewares))
+	mws = append(append(mws, global...), w.middlewares...)
+	w.mwMu.Unlock()
+	w.handler.Store(workerBox{chain(w.worker, mws...)})
+}
+
+type workerBox struct {
+	Worker
+}
+
+// 获取经过中间件包装后的任务执行器
+func (w *WorkerWithFunc) getHandler() Worker {
+	if box, ok := w.handler.Load().(workerBox); ok {
+		return box.Worker
+	}
+	return w.worker
+}
+
+// 超时中间件: 任务的ctx在d后超时, 需要任务本身响应ctx
+func TimeoutMiddleware(d time.Duration) Middleware {
+	return func(next Worker) Worker {
+		return WorkerFunc(func(ctx context.Context, task *Task) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			next.Exec(ctx, task)
+		})
+	}
+}
+
+// 恢复中间件: 任务panic时设置为StateFailed, 不触发panic回调, f可以为nil
+func RecoverMiddleware(f func(task *Task, e interface{})) Middleware {
+	return func(next Worker) Worker {
+		return WorkerFunc(func(ctx context.Context, task *Task) {
+			defer func() {
+				if e := recover(); e != nil {
+					task.Result = Result{State: StateFailed, Message: fmt.Sprint(e)}
+					if f != nil {
+						f(task, e)
+					}
+				}
+			}()
+			next.Exec(ctx, task)
+		})
+	}
+}
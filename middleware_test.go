@@ -0,0 +1,82 @@
+package job
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func recordMiddleware(mu *sync.Mutex, calls *[]string, name string) Middleware {
+	return func(next Worker) Worker {
+		return WorkerFunc(func(ctx context.Context, task *Task) {
+			mu.Lock()
+			*calls = append(*calls, name)
+			mu.Unlock()
+			next.Exec(ctx, task)
+		})
+	}
+}
+
+// 全局中间件在topic中间件之外, 各自按添加顺序由外到内
+func TestMiddlewareOrder(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	done := make(chan struct{}, 1)
+	j.AddFunc(q, "m", func(ctx context.Context, task *Task) {
+		mu.Lock()
+		calls = append(calls, "handler")
+		mu.Unlock()
+		done <- struct{}{}
+	}, 1)
+	j.UseTopic("m", recordMiddleware(&mu, &calls, "topic1"), recordMiddleware(&mu, &calls, "topic2"))
+	j.Use(recordMiddleware(&mu, &calls, "global1"))
+	j.Use(recordMiddleware(&mu, &calls, "global2"))
+	j.Enqueue(context.Background(), "m", "x")
+	j.Start()
+	defer stopJob(t, j)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("task not executed")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"global1", "global2", "topic1", "topic2", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls %v, want %v", calls, want)
+	}
+	if err := j.UseTopic("none"); err != ErrWorkerNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	var recovered interface{}
+	h := RecoverMiddleware(func(task *Task, e interface{}) { recovered = e })(WorkerFunc(func(ctx context.Context, task *Task) {
+		panic("boom")
+	}))
+	task := &Task{}
+	h.Exec(context.Background(), task)
+	if recovered != "boom" || task.Result.State != StateFailed || task.Result.Message != "boom" {
+		t.Fatalf("recovered %v, result %+v", recovered, task.Result)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	var err error
+	h := TimeoutMiddleware(10 * time.Millisecond)(WorkerFunc(func(ctx context.Context, task *Task) {
+		<-ctx.Done()
+		err = ctx.Err()
+	}))
+	h.Exec(context.Background(), &Task{})
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
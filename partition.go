@@ -0,0 +1,142 @@
+package job
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	//默认每个分区key最多缓冲的任务数
+	defaultPartitionBuffer = 100
+)
+
+// 按分区key串行执行任务: 同一个key同时只有一个任务在执行, 其余任务按到达顺序缓冲
+// 不同key之间仍然并发执行
+type partitioner struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int
+	// 正在执行中的key及其等待执行的任务, key存在即表示该key有任务在执行
+	keys map[string][]*Task
+}
+
+func newPartitioner(max int) *partitioner {
+	if max <= 0 {
+		max = defaultPartitionBuffer
+	}
+	p := &partitioner{max: max, keys: make(map[string][]*Task)}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// 返回true表示该key空闲, 任务需要立即提交执行
+// 返回false表示任务已放入该key的缓冲, 由正在执行的任务处理完后接着执行
+// 缓冲满时阻塞, 从而反压到拉取协程
+func (p *partitioner) acquire(task *Task) bool {
+	key := task.PartitionKey
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		pending, active := p.keys[key]
+		if !active {
+			p.keys[key] = nil
+			return true
+		}
+		if len(pending) < p.max {
+			p.keys[key] = append(pending, task)
+			return false
+		}
+		p.cond.Wait()
+	}
+}
+
+// 取出该key下一个等待执行的任务, 没有则释放该key并返回nil
+func (p *partitioner) next(key string) *Task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.cond.Broadcast()
+
+	pending := p.keys[key]
+	if len(pending) == 0 {
+		delete(p.keys, key)
+		return nil
+	}
+	task := pending[0]
+	pending[0] = nil
+	p.keys[key] = pending[1:]
+	return task
+}
+
+// 设置每个分区key最多缓冲的任务数, 需要在Run之前设置
+func (w *WorkerWithFunc) SetPartitionBuffer(n int) {
+	w.partitions = newPartitioner(n)
+}
+
+// 执行任务, 带分区key的任务执行完后在同一个协程中继续执行该key缓冲的任务
+func (w *WorkerWithFunc) processOrdered(task *Task) {
+	if task.PartitionKey == "" {
+		w.processTask(task)
+		return
+	}
+	for t := task; t != nil; t = w.partitions.next(t.PartitionKey) {
+		w.processTask(t)
+	}
+}
+
+// 任务提交失败时释放分区key, 该key缓冲的任务同样不执行也不ack, 如果queue服务支持, 会进行消息重放
+func (w *WorkerWithFunc) releasePartition(task *Task) {
+	if task.PartitionKey == "" {
+		return
+	}
+	for t := w.partitions.next(task.PartitionKey); t != nil; t = w.partitions.next(task.PartitionKey) {
+		atomic.AddInt64(&w.inflight, -1)
+	}
+}
+
+// 批量任务按分区key串行: 批次中任一key仍有批次在执行时阻塞, 直到这些key全部空闲后占用
+// 凑批协程只有一个, 因此同一个key的任务按到达顺序分批执行
+func (p *partitioner) lockBatch(tasks []*Task) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		if task.PartitionKey != "" && !seen[task.PartitionKey] {
+			seen[task.PartitionKey] = true
+			keys = append(keys, task.PartitionKey)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.free(keys) {
+		p.cond.Wait()
+	}
+	for _, key := range keys {
+		p.keys[key] = nil
+	}
+	return keys
+}
+
+func (p *partitioner) free(keys []string) bool {
+	for _, key := range keys {
+		if _, active := p.keys[key]; active {
+			return false
+		}
+	}
+	return true
+}
+
+// 批次执行完后释放占用的key
+func (p *partitioner) unlockBatch(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, key := range keys {
+		delete(p.keys, key)
+	}
+	p.cond.Broadcast()
+}
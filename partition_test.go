@@ -0,0 +1,161 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 同一个key按入队顺序串行执行, 不同key并发执行
+func TestPartitionOrdering(t *testing.T) {
+	const keys, perKey = 4, 30
+	q := newMemQueue()
+	j := New()
+	var (
+		mu      sync.Mutex
+		order   = make(map[string][]int)
+		running = make(map[string]bool)
+		overlap int64
+		done    int64
+	)
+	j.AddFunc(q, "p", func(ctx context.Context, task *Task) {
+		key := task.PartitionKey
+		mu.Lock()
+		if running[key] {
+			atomic.AddInt64(&overlap, 1)
+		}
+		running[key] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+		seq, _ := strconv.Atoi(task.Message)
+
+		mu.Lock()
+		running[key] = false
+		order[key] = append(order[key], seq)
+		mu.Unlock()
+		atomic.AddInt64(&done, 1)
+	}, 8)
+	w, _ := j.getWorker("p")
+	w.SetPartitionBuffer(5)
+
+	var tasks []Task
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			task := GenTask("p", strconv.Itoa(i))
+			task.PartitionKey = fmt.Sprintf("k%d", k)
+			tasks = append(tasks, task)
+		}
+	}
+	j.BatchEnqueueWithTask(context.Background(), "p", tasks)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 5*time.Second, func() bool { return atomic.LoadInt64(&done) == keys*perKey })
+	if n := atomic.LoadInt64(&overlap); n != 0 {
+		t.Fatalf("%d tasks ran concurrently with the same key", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for key, seqs := range order {
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("key %s out of order: %v", key, seqs)
+			}
+		}
+	}
+}
+
+// 提交到协程池失败时释放分区key, 后续同key的任务不会阻塞拉取
+func TestPartitionReleasedOnSubmitError(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	j.AddFunc(q, "p", func(ctx context.Context, task *Task) {}, 1)
+	w, _ := j.getWorker("p")
+	w.SetPartitionBuffer(1)
+	// 协程池关闭后Submit返回错误
+	w.Release()
+
+	tasks := make([]Task, 5)
+	for i := range tasks {
+		tasks[i] = GenTask("p", "m")
+		tasks[i].PartitionKey = "k"
+	}
+	j.BatchEnqueueWithTask(context.Background(), "p", tasks)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 2*time.Second, func() bool { return q.len("p") == 0 })
+	waitFor(t, 2*time.Second, func() bool {
+		w.partitions.mu.Lock()
+		defer w.partitions.mu.Unlock()
+		return len(w.partitions.keys) == 0 && atomic.LoadInt64(&w.inflight) == 0
+	})
+}
+
+// 批量任务: 含有相同key的批次串行执行, 同一个key的任务按入队顺序分批
+func TestPartitionBatch(t *testing.T) {
+	const keys, perKey = 3, 20
+	q := newMemQueue()
+	j := New()
+	var (
+		mu      sync.Mutex
+		order   = make(map[string][]int)
+		running = make(map[string]bool)
+		overlap int64
+		done    int64
+	)
+	j.AddBatchFunc(q, "p", func(ctx context.Context, tasks []*Task) {
+		mu.Lock()
+		for _, task := range tasks {
+			if running[task.PartitionKey] {
+				atomic.AddInt64(&overlap, 1)
+			}
+		}
+		for _, task := range tasks {
+			running[task.PartitionKey] = true
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond * 2)
+
+		mu.Lock()
+		for _, task := range tasks {
+			running[task.PartitionKey] = false
+			seq, _ := strconv.Atoi(task.Message)
+			order[task.PartitionKey] = append(order[task.PartitionKey], seq)
+		}
+		mu.Unlock()
+		atomic.AddInt64(&done, int64(len(tasks)))
+	}, 4, 4, time.Millisecond)
+
+	var tasks []Task
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			task := GenTask("p", strconv.Itoa(i))
+			task.PartitionKey = fmt.Sprintf("k%d", k)
+			tasks = append(tasks, task)
+		}
+	}
+	j.BatchEnqueueWithTask(context.Background(), "p", tasks)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 5*time.Second, func() bool { return atomic.LoadInt64(&done) == keys*perKey })
+	if n := atomic.LoadInt64(&overlap); n != 0 {
+		t.Fatalf("%d batches ran concurrently with the same key", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for key, seqs := range order {
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("key %s out of order: %v", key, seqs)
+			}
+		}
+	}
+}
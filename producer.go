@@ -0,0 +1,508 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/navi-tt/job/internal/queue"
+)
+
+var (
+	ErrEnqueueFailed = errors.New("enqueue failed")
+)
+
+// 生产者: topic与queue驱动的映射独立于worker, 只发布消息的服务不需要注册worker
+type Producer struct {
+	compressStats compressStats // 64位原子操作, 放在第一个字段保证对齐
+
+	mu       sync.RWMutex
+	queues   map[string]queue.Queue // topic精确匹配
+	prefixes []prefixRoute          // topic前缀匹配, 按添加顺序优先
+	def      queue.Queue            // 默认queue驱动
+
+	interceptors []EnqueueInterceptor
+
+	codecs   map[string]Codec // topic对应的编解码器
+	defCodec Codec            // 默认编解码器, 为nil时使用JSON
+
+	compressions   map[string]compression // topic对应的压缩配置
+	defCompression compression            // 默认压缩配置, Compressor为nil时不压缩
+
+	keys    map[string]KeyProvider // topic对应的加密密钥, 消费端也用于解密
+	defKeys KeyProvider            // 默认加密密钥, 为nil时不加密
+
+	signings   map[string]*HMACKeys // topic对应的签名密钥, 消费端也用于验签
+	defSigning *HMACKeys            // 默认签名密钥, 为nil时不签名
+
+	claimChecks map[string]claimCheck // topic对应的大消息存储, 消费端也用于取回消息体
+	defClaim    claimCheck            // 默认大消息存储, store为nil时不存储
+
+	// 优先查找的queue, Job用于查找worker对应的queue
+	lookup func(topic string) queue.Queue
+}
+
+type prefixRoute struct {
+	prefix string
+	q      queue.Queue
+}
+
+func NewProducer() *Producer {
+	p := new(Producer)
+	p.queues = make(map[string]queue.Queue)
+	p.codecs = make(map[string]Codec)
+	p.compressions = make(map[string]compression)
+	p.keys = make(map[string]KeyProvider)
+	p.signings = make(map[string]*HMACKeys)
+	p.claimChecks = make(map[string]claimCheck)
+	return p
+}
+
+// 设置topic对应的queue驱动, 不传topic时设置为默认queue驱动, 对其余topic生效
+func (p *Producer) AddQueue(q queue.Queue, topics ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(topics) == 0 {
+		p.def = q
+		return
+	}
+	for _, topic := range topics {
+		p.queues[topic] = q
+	}
+}
+
+// 设置topic前缀对应的queue驱动, 如 "order:" 匹配所有以 "order:" 开头的topic
+func (p *Producer) AddRoute(prefix string, q queue.Queue) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prefixes = append(p.prefixes, prefixRoute{prefix: prefix, q: q})
+}
+
+// 设置topic的编解码器, 不传topic时设置为默认编解码器
+// 消费端根据消息中的content-type自动选择编解码器, 不需要设置
+func (p *Producer) SetCodec(c Codec, topics ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(topics) == 0 {
+		p.defCodec = c
+		return
+	}
+	for _, topic := range topics {
+		p.codecs[topic] = c
+	}
+}
+
+func (p *Producer) getCodec(topic string) Codec {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if c, ok := p.codecs[topic]; ok {
+		return c
+	}
+	return p.defCodec
+}
+
+// 设置topic的压缩算法, 编码后超过threshold字节的消息压缩后入队, threshold<=0时使用DefaultCompressThreshold
+// c为nil时不压缩, 不传topic时设置为默认压缩配置, 消费端根据消息中的content-encoding自动解压
+func (p *Producer) SetCompression(c Compressor, threshold int, topics ...string) {
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	cp := compression{c: c, threshold: threshold}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(topics) == 0 {
+		p.defCompression = cp
+		return
+	}
+	for _, topic := range topics {
+		p.compressions[topic] = cp
+	}
+}
+
+func (p *Producer) getCompression(topic string) compression {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if cp, ok := p.compressions[topic]; ok {
+		return cp
+	}
+	return p.defCompression
+}
+
+// 设置topic的加密密钥, 编码和压缩后使用AES-GCM加密, kp为nil时不加密, 不传topic时设置为默认密钥
+// 同一个Job中消费该topic时使用相同的密钥解密
+func (p *Producer) SetEncryption(kp KeyProvider, topics ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(topics) == 0 {
+		p.defKeys = kp
+		return
+	}
+	for _, topic := range topics {
+		p.keys[topic] = kp
+	}
+}
+
+func (p *Producer) getKeyProvider(topic string) KeyProvider {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if kp, ok := p.keys[topic]; ok {
+		return kp
+	}
+	return p.defKeys
+}
+
+// 设置topic的签名密钥, 入队时对编码、压缩、加密后的消息签名, keys为nil时不签名, 不传topic时设置为默认签名密钥
+// 同一个Job中消费该topic时验证签名, 没有签名或签名不正确的消息不会执行, EnqueueRaw的消息没有签名
+func (p *Producer) SetSigning(keys *HMACKeys, topics ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(topics) == 0 {
+		p.defSigning = keys
+		return
+	}
+	for _, topic := range topics {
+		p.signings[topic] = keys
+	}
+}
+
+func (p *Producer) getSigning(topic string) *HMACKeys {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if keys, ok := p.signings[topic]; ok {
+		return keys
+	}
+	return p.defSigning
+}
+
+// 设置topic的大消息存储(claim-check), Message和Payload合计超过threshold字节时存入store, 任务中只保留ClaimRef
+// threshold<=0时使用DefaultClaimThreshold, store为nil时不存储, 不传topic时设置为默认配置
+// 同一个Job中消费该topic时执行前取回消息体, ack成功后删除; 存入失败时整批入队失败
+func (p *Producer) SetClaimCheck(store ClaimStore, threshold int, topics ...string) {
+	if threshold <= 0 {
+		threshold = DefaultClaimThreshold
+	}
+	cc := claimCheck{store: store, threshold: threshold}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(topics) == 0 {
+		p.defClaim = cc
+		return
+	}
+	for _, topic := range topics {
+		p.claimChecks[topic] = cc
+	}
+}
+
+func (p *Producer) getClaimCheck(topic string) claimCheck {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if cc, ok := p.claimChecks[topic]; ok {
+		return cc
+	}
+	return p.defClaim
+}
+
+// 生产者统计: 压缩的消息数、压缩前后字节数、节省的字节数、压缩率
+func (p *Producer) Stats() map[string]int64 {
+	return p.compressStats.stats()
+}
+
+// 添加入队拦截器, 按添加顺序由外到内, EnqueueRaw不经过拦截器
+func (p *Producer) Use(interceptors ...EnqueueInterceptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interceptors = append(p.interceptors[:len(p.interceptors):len(p.interceptors)], interceptors...)
+}
+
+// 获取topic对应的queue驱动, 依次按精确匹配、前缀匹配、默认驱动查找
+func (p *Producer) GetQueueByTopic(topic string) queue.Queue {
+	if p.lookup != nil {
+		if q := p.lookup(topic); q != nil {
+			return q
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if q, ok := p.queues[topic]; ok {
+		return q
+	}
+	for _, r := range p.prefixes {
+		if strings.HasPrefix(topic, r.prefix) {
+			return r.q
+		}
+	}
+	return p.def
+}
+
+func (p *Producer) getInterceptors() []EnqueueInterceptor {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.interceptors
+}
+
+// 入队结果
+type EnqueueResult struct {
+	Id        string // 任务id
+	MessageId string // 队列服务生成的消息id, 队列驱动实现queue.MessageIdQueue/queue.BatchResultQueue时返回
+	Err       error
+}
+
+//消息入队 -- 原始message
+func (p *Producer) Enqueue(ctx context.Context, topic string, message string, args ...interface{}) (bool, error) {
+	task := GenTask(topic, message)
+	return p.EnqueueWithTask(ctx, topic, task, args...)
+}
+
+//消息入队 -- 二进制消息
+func (p *Producer) EnqueueBytes(ctx context.Context, topic string, payload []byte, args ...interface{}) (bool, error) {
+	task := GenBytesTask(topic, payload)
+	return p.EnqueueWithTask(ctx, topic, task, args...)
+}
+
+//消息入队 -- Task数据结构
+func (p *Producer) EnqueueWithTask(ctx context.Context, topic string, task Task, args ...interface{}) (bool, error) {
+	r, err := p.EnqueueWithResult(ctx, topic, task, args...)
+	if err == nil {
+		err = r.Err
+	}
+	return err == nil, err
+}
+
+//消息入队 -- Task数据结构，返回任务id和队列服务生成的消息id
+func (p *Producer) EnqueueWithResult(ctx context.Context, topic string, task Task, args ...interface{}) (EnqueueResult, error) {
+	q := p.GetQueueByTopic(topic)
+	if q == nil {
+		return EnqueueResult{Id: task.Id, Err: ErrQueueNotExist}, ErrQueueNotExist
+	}
+
+	if task.Topic == "" {
+		task.Topic = topic
+	}
+	results, err := p.enqueueTasks(ctx, q, topic, []*Task{&task}, false, args...)
+	if err == nil && len(results) == 0 {
+		err = ErrEnqueueFailed
+	}
+	if err != nil {
+		return EnqueueResult{Id: task.Id, Err: err}, err
+	}
+	return results[0], nil
+}
+
+//消息入队 -- 原始message不带有task结构原生消息
+func (p *Producer) EnqueueRaw(ctx context.Context, topic string, message string, args ...interface{}) (bool, error) {
+	q := p.GetQueueByTopic(topic)
+	if q == nil {
+		return false, ErrQueueNotExist
+	}
+
+	return q.Enqueue(ctx, topic, message, args...)
+}
+
+//消息入队 -- 原始message
+func (p *Producer) BatchEnqueue(ctx context.Context, topic string, messages []string, args ...interface{}) (bool, error) {
+	tasks := make([]Task, len(messages))
+	for k, message := range messages {
+		tasks[k] = GenTask(topic, message)
+	}
+	return p.BatchEnqueueWithTask(ctx, topic, tasks, args...)
+}
+
+//消息入队 -- 二进制消息
+func (p *Producer) BatchEnqueueBytes(ctx context.Context, topic string, payloads [][]byte, args ...interface{}) (bool, error) {
+	tasks := make([]Task, len(payloads))
+	for k, payload := range payloads {
+		tasks[k] = GenBytesTask(topic, payload)
+	}
+	return p.BatchEnqueueWithTask(ctx, topic, tasks, args...)
+}
+
+//消息入队 -- Task数据结构，全部成功才返回true，error为第一个失败的错误
+func (p *Producer) BatchEnqueueWithTask(ctx context.Context, topic string, tasks []Task, args ...interface{}) (bool, error) {
+	results, err := p.BatchEnqueueWithResult(ctx, topic, tasks, args...)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			return false, r.Err
+		}
+	}
+	return true, nil
+}
+
+//消息入队 -- Task数据结构，返回每个任务的结果，与tasks一一对应，可以只重试失败的任务
+//error不为nil时整批失败，此时每个结果的Err也会设置为该错误
+func (p *Producer) BatchEnqueueWithResult(ctx context.Context, topic string, tasks []Task, args ...interface{}) ([]EnqueueResult, error) {
+	ts := make([]*Task, len(tasks))
+	for k := range tasks {
+		task := tasks[k]
+		if task.Topic == "" {
+			task.Topic = topic
+		}
+		ts[k] = &task
+	}
+
+	q := p.GetQueueByTopic(topic)
+	if q == nil {
+		return failedResults(ts, ErrQueueNotExist), ErrQueueNotExist
+	}
+	results, err := p.enqueueTasks(ctx, q, topic, ts, true, args...)
+	if err != nil {
+		return failedResults(ts, err), err
+	}
+	return results, nil
+}
+
+func failedResults(tasks []*Task, err error) []EnqueueResult {
+	results := make([]EnqueueResult, len(tasks))
+	for k, task := range tasks {
+		results[k] = EnqueueResult{Id: task.Id, Err: err}
+	}
+	return results
+}
+
+//经过入队拦截器后编码并调用队列驱动，batch为true时调用BatchEnqueue
+func (p *Producer) enqueueTasks(ctx context.Context, q queue.Queue, topic string, tasks []*Task, batch bool, args ...interface{}) ([]EnqueueResult, error) {
+	now := unixMilli(time.Now())
+	for _, task := range tasks {
+		if task.EnqueuedAt == 0 {
+			task.EnqueuedAt = now
+		}
+	}
+
+	codec := p.getCodec(topic)
+	// 先压缩再加密, 密文无法压缩, 签名在最后
+	fns := []envelopeFunc{
+		p.compress(p.getCompression(topic)),
+		encrypt(topic, p.getKeyProvider(topic)),
+		sign(topic, p.getSigning(topic)),
+	}
+	h := func(ctx context.Context, topic string, tasks []*Task, args ...interface{}) ([]EnqueueResult, error) {
+		results := make([]EnqueueResult, len(tasks))
+		// 编码失败的任务单独返回错误, 不影响其他任务入队
+		arr := make([][]byte, 0, len(tasks))
+		idx := make([]int, 0, len(tasks))
+		_, binary := q.(queue.BytesQueue)
+		for k, task := range tasks {
+			results[k].Id = task.Id
+			b, err := encodeTask(task, codec, fns...)
+			if err != nil {
+				results[k].Err = err
+				continue
+			}
+			if !binary {
+				// 只能传递文本的队列驱动, 二进制信封转为base64
+				b = textEnvelope(b)
+			}
+			arr = append(arr, b)
+			idx = append(idx, k)
+		}
+		if len(arr) == 0 {
+			return results, nil
+		}
+		if err := checkMessageSize(ctx, arr); err != nil {
+			return nil, err
+		}
+
+		if !batch {
+			for k, b := range arr {
+				results[idx[k]].MessageId, results[idx[k]].Err = enqueueOne(ctx, q, topic, b, args...)
+			}
+			return results, nil
+		}
+		rs, err := batchEnqueue(ctx, q, topic, arr, args...)
+		if err != nil {
+			return nil, err
+		}
+		for k, r := range rs {
+			results[idx[k]].MessageId = r.MessageId
+			results[idx[k]].Err = r.Err
+		}
+		return results, nil
+	}
+	// 大消息在拦截器之前存入ClaimStore, 拦截器检查的是替换为引用后的任务
+	cc := p.getClaimCheck(topic)
+	claimed := make([]bool, len(tasks))
+	for k, task := range tasks {
+		ok, err := cc.offload(ctx, task)
+		if err != nil {
+			p.discardClaims(ctx, cc, tasks, claimed, nil)
+			return nil, err
+		}
+		claimed[k] = ok
+	}
+
+	results, err := chainEnqueue(h, p.getInterceptors()...)(ctx, topic, tasks, args...)
+	if err == nil && len(results) != len(tasks) {
+		err = ErrEnqueueFailed
+	}
+	if err != nil {
+		results = nil
+	}
+	p.discardClaims(ctx, cc, tasks, claimed, results)
+	return results, err
+}
+
+// 删除入队失败的任务存入ClaimStore的消息体, results为nil时整批失败
+func (p *Producer) discardClaims(ctx context.Context, cc claimCheck, tasks []*Task, claimed []bool, results []EnqueueResult) {
+	for k, task := range tasks {
+		if claimed[k] && (results == nil || results[k].Err != nil) {
+			cc.discard(ctx, task)
+		}
+	}
+}
+
+// 队列驱动实现queue.BytesQueue时不转换为string
+func enqueueOne(ctx context.Context, q queue.Queue, topic string, message []byte, args ...interface{}) (string, error) {
+	if mq, ok := q.(queue.MessageIdQueue); ok {
+		return mq.EnqueueWithId(ctx, topic, string(message), args...)
+	}
+	var ok bool
+	var err error
+	if bq, isBytes := q.(queue.BytesQueue); isBytes {
+		ok, err = bq.EnqueueBytes(ctx, topic, message, args...)
+	} else {
+		ok, err = q.Enqueue(ctx, topic, string(message), args...)
+	}
+	if err == nil && !ok {
+		err = ErrEnqueueFailed
+	}
+	return "", err
+}
+
+// 队列驱动不支持单条结果时, 整批结果相同
+func batchEnqueue(ctx context.Context, q queue.Queue, topic string, messages [][]byte, args ...interface{}) ([]QueueSendResult, error) {
+	if bq, ok := q.(queue.BatchResultQueue); ok {
+		rs, err := bq.BatchEnqueueWithResult(ctx, topic, toStrings(messages), args...)
+		if err == nil && len(rs) != len(messages) {
+			err = fmt.Errorf("batch enqueue returned %d results for %d messages", len(rs), len(messages))
+		}
+		return rs, err
+	}
+	var ok bool
+	var err error
+	if bq, isBytes := q.(queue.BytesQueue); isBytes {
+		ok, err = bq.BatchEnqueueBytes(ctx, topic, messages, args...)
+	} else {
+		ok, err = q.BatchEnqueue(ctx, topic, toStrings(messages), args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrEnqueueFailed
+	}
+	return make([]QueueSendResult, len(messages)), nil
+}
+
+func toStrings(messages [][]byte) []string {
+	arr := make([]string, len(messages))
+	for k, m := range messages {
+		arr[k] = string(m)
+	}
+	return arr
+}
@@ -0,0 +1,121 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// 依次按精确匹配、前缀匹配、默认驱动查找
+func TestProducerRouting(t *testing.T) {
+	exact, order, def := newMemQueue(), newMemQueue(), newMemQueue()
+	p := NewProducer()
+	ctx := context.Background()
+	if _, err := p.Enqueue(ctx, "x", "m"); err != ErrQueueNotExist {
+		t.Fatal(err)
+	}
+
+	p.AddQueue(exact, "order:vip")
+	p.AddRoute("order:", order)
+	p.AddQueue(def)
+	for _, topic := range []string{"order:vip", "order:1", "user:1"} {
+		if ok, err := p.Enqueue(ctx, topic, "m"); !ok || err != nil {
+			t.Fatal(topic, err)
+		}
+	}
+	if exact.len("order:vip") != 1 || order.len("order:1") != 1 || def.len("user:1") != 1 {
+		t.Fatalf("routing: exact=%v order=%v def=%v", exact.items, order.items, def.items)
+	}
+	if p.GetQueueByTopic("order:2") != order {
+		t.Fatal("prefix route not used")
+	}
+}
+
+// Job的worker的queue优先于Producer的路由
+func TestJobProducerRouting(t *testing.T) {
+	wq, def := newMemQueue(), newMemQueue()
+	j := New()
+	j.AddQueue(def)
+	j.AddFunc(wq, "w", func(ctx context.Context, task *Task) {}, 1)
+	ctx := context.Background()
+	j.Enqueue(ctx, "w", "m")
+	j.Enqueue(ctx, "other", "m")
+	if wq.len("w") != 1 || def.len("other") != 1 || def.len("w") != 0 {
+		t.Fatalf("worker=%v default=%v", wq.items, def.items)
+	}
+	if j.GetQueueByTopic("w") != wq {
+		t.Fatal("worker queue not used")
+	}
+}
+
+// 返回消息id和单条结果的队列, 消息内容为"bad"时该条失败
+type resultQueue struct {
+	*memQueue
+	seq int
+}
+
+func (q *resultQueue) EnqueueWithId(ctx context.Context, key string, message string, args ...interface{}) (string, error) {
+	q.memQueue.Enqueue(ctx, key, message)
+	q.seq++
+	return fmt.Sprintf("msg-%d", q.seq), nil
+}
+
+func (q *resultQueue) BatchEnqueueWithResult(ctx context.Context, key string, messages []string, args ...interface{}) ([]QueueSendResult, error) {
+	results := make([]QueueSendResult, len(messages))
+	for k, m := range messages {
+		task, _ := DecodeStringTask(m)
+		if task.Message == "bad" {
+			results[k].Err = errors.New("rejected")
+			continue
+		}
+		id, _ := q.EnqueueWithId(ctx, key, m)
+		results[k].MessageId = id
+	}
+	return results, nil
+}
+
+func TestEnqueueWithResult(t *testing.T) {
+	q := &resultQueue{memQueue: newMemQueue()}
+	p := NewProducer()
+	p.AddQueue(q)
+	ctx := context.Background()
+
+	task := GenTask("t", "m")
+	r, err := p.EnqueueWithResult(ctx, "t", task)
+	if err != nil || r.Err != nil || r.Id != task.Id || r.MessageId != "msg-1" {
+		t.Fatalf("result %+v, %v", r, err)
+	}
+
+	tasks := []Task{GenTask("t", "a"), GenTask("t", "bad"), GenTask("t", "c")}
+	results, err := p.BatchEnqueueWithResult(ctx, "t", tasks)
+	if err != nil || len(results) != 3 {
+		t.Fatal(results, err)
+	}
+	for k, r := range results {
+		if r.Id != tasks[k].Id {
+			t.Fatalf("result %d id %s, want %s", k, r.Id, tasks[k].Id)
+		}
+	}
+	if results[0].MessageId != "msg-2" || results[1].Err == nil || results[2].MessageId != "msg-3" {
+		t.Fatalf("results %+v", results)
+	}
+	if ok, err := p.BatchEnqueueWithTask(ctx, "t", tasks); ok || err == nil {
+		t.Fatal("partial failure reported as success")
+	}
+}
+
+// 没有queue时整批失败, 每个结果都带有错误
+func TestEnqueueWithResultNoQueue(t *testing.T) {
+	p := NewProducer()
+	tasks := []Task{GenTask("t", "a"), GenTask("t", "b")}
+	results, err := p.BatchEnqueueWithResult(context.Background(), "t", tasks)
+	if err != ErrQueueNotExist || len(results) != 2 {
+		t.Fatal(results, err)
+	}
+	for k, r := range results {
+		if r.Id != tasks[k].Id || r.Err != ErrQueueNotExist {
+			t.Fatalf("result %+v", r)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidRate = errors.New("rate must be positive")
+
+// 限流器, 拉取协程在把任务交给执行协程前调用Wait获取令牌
+type Limiter interface {
+	// 阻塞直到获取到n个令牌, ctx取消时返回错误
+	Wait(ctx context.Context, n int) error
+}
+
+// 令牌桶: 每秒生成rate个令牌, 最多积攒burst个
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// rate小于等于0时返回ErrInvalidRate, 取消限流请使用SetRateLimit(0, 0)或者SetRateLimiter(nil)
+func NewTokenBucket(rate float64, burst int) (Limiter, error) {
+	if rate <= 0 {
+		return nil, ErrInvalidRate
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}, nil
+}
+
+func (b *tokenBucket) Wait(ctx context.Context, n int) error {
+	need := float64(n)
+	if need > b.burst {
+		need = b.burst
+	}
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= need {
+			b.tokens -= need
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+type limiterBox struct {
+	Limiter
+}
+
+// 设置worker的限流器, 运行中可以随时替换, 传nil取消限流
+func (w *WorkerWithFunc) SetRateLimiter(l Limiter) {
+	w.limiter.Store(limiterBox{l})
+}
+
+// 设置worker令牌桶限流: 每秒最多rate个任务, 允许突发burst个, rate<=0取消限流
+func (w *WorkerWithFunc) SetRateLimit(rate float64, burst int) {
+	if rate <= 0 {
+		w.SetRateLimiter(nil)
+		return
+	}
+	l, _ := NewTokenBucket(rate, burst)
+	w.SetRateLimiter(l)
+}
+
+func (w *WorkerWithFunc) rateLimiter() Limiter {
+	box, _ := w.limiter.Load().(limiterBox)
+	return box.Limiter
+}
+
+// 获取一个任务的令牌, 并记录被限流阻塞的时间
+func (w *WorkerWithFunc) waitRateLimit() error {
+	l := w.rateLimiter()
+	if l == nil {
+		return nil
+	}
+	start := time.Now()
+	err := l.Wait(w.Job().ctx, 1)
+	if d := time.Since(start); d > time.Millisecond {
+		atomic.AddInt64(&w.throttledCount, 1)
+		atomic.AddInt64(&w.throttledTime, int64(d))
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/navi-tt/job/internal/log"
+)
+
+// 分布式限流器, 多个Job实例共享同一个key的配额
+type DistributedLimiter interface {
+	// 尝试获取n个令牌, 获取失败时返回需要等待的时间
+	Allow(ctx context.Context, key string, n int) (ok bool, retryAfter time.Duration, err error)
+}
+
+// 执行lua脚本的redis客户端, 由使用方用自己的redis客户端适配, 如go-redis:
+//
+//	job.ScriptRunnerFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return rdb.Eval(ctx, script, keys, args...).Result()
+//	})
+type ScriptRunner interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+type ScriptRunnerFunc func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+
+func (f ScriptRunnerFunc) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return f(ctx, script, keys, args...)
+}
+
+// GCRA(通用信元速率算法), key中只保存理论到达时间(TAT, 微秒), 时间取redis服务器时间, 避免实例间时钟偏差
+// ARGV[1] 每个令牌的间隔(微秒) ARGV[2] 允许突发的令牌数 ARGV[3] 本次获取的令牌数
+// 返回 {是否允许, 需要等待的微秒数}
+const gcraScript = `
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval * n
+local diff = now - (newTat - interval * burst)
+if diff < 0 then
+	return {0, math.ceil(-diff)}
+end
+redis.call('SET', KEYS[1], string.format('%d', newTat), 'PX', math.ceil((newTat - now) / 1000) + 1)
+return {1, 0}
+`
+
+type redisGCRA struct {
+	r        ScriptRunner
+	interval int64
+	burst    int
+}
+
+// 基于redis的GCRA限流: 所有实例合计每秒最多rate个令牌, 允许突发burst个, rate小于等于0时返回ErrInvalidRate
+func NewRedisGCRA(r ScriptRunner, rate float64, burst int) (DistributedLimiter, error) {
+	if rate <= 0 {
+		return nil, ErrInvalidRate
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	interval := int64(float64(time.Second/time.Microsecond) / rate)
+	if interval <= 0 {
+		interval = 1
+	}
+	return &redisGCRA{r: r, interval: interval, burst: burst}, nil
+}
+
+func (g *redisGCRA) Allow(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	res, err := g.r.Eval(ctx, gcraScript, []string{key}, g.interval, g.burst, n)
+	if err != nil {
+		return false, 0, err
+	}
+	arr, ok := res.([]interface{})
+	if !ok || len(arr) != 2 {
+		return false, 0, fmt.Errorf("unexpected gcra result: %v", res)
+	}
+	allowed, _ := arr[0].(int64)
+	retry, _ := arr[1].(int64)
+	return allowed == 1, time.Duration(retry) * time.Microsecond, nil
+}
+
+// 分布式限流器适配为Limiter, 存储服务异常时放行, 避免限流存储故障导致停止消费
+type distributedLimiter struct {
+	d   DistributedLimiter
+	key string
+}
+
+func NewDistributedLimiter(d DistributedLimiter, key string) Limiter {
+	return &distributedLimiter{d: d, key: key}
+}
+
+func (l *distributedLimiter) Wait(ctx context.Context, n int) error {
+	for {
+		ok, retryAfter, err := l.d.Allow(ctx, l.key, n)
+		if err != nil {
+			log.Errorf("distributed_limiter_error: %v, %v", err, l.key)
+			return nil
+		}
+		if ok {
+			return nil
+		}
+		if retryAfter < time.Millisecond {
+			retryAfter = time.Millisecond
+		}
+
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
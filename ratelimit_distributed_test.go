@@ -0,0 +1,93 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+// 在miniredis中执行gcraScript
+func newRedisRunner(t *testing.T) ScriptRunner {
+	t.Helper()
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	conn, err := redis.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var mu sync.Mutex
+	return ScriptRunnerFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		cmd := []interface{}{script, len(keys)}
+		for _, key := range keys {
+			cmd = append(cmd, key)
+		}
+		return conn.Do("EVAL", append(cmd, args...)...)
+	})
+}
+
+func TestRedisGCRA(t *testing.T) {
+	r := newRedisRunner(t)
+	g, _ := NewRedisGCRA(r, 100, 3)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if ok, _, err := g.Allow(ctx, "k", 1); !ok || err != nil {
+			t.Fatalf("burst token %d: %v %v", i, ok, err)
+		}
+	}
+	ok, retry, err := g.Allow(ctx, "k", 1)
+	if ok || err != nil || retry <= 0 || retry > 10*time.Millisecond {
+		t.Fatalf("over burst: %v %v %v", ok, retry, err)
+	}
+
+	// 两个实例共享同一个key的配额, 总速率不超过rate
+	g2, _ := NewRedisGCRA(r, 100, 3)
+	l1, l2 := NewDistributedLimiter(g, "shared"), NewDistributedLimiter(g2, "shared")
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		l1.Wait(ctx, 1)
+		l2.Wait(ctx, 1)
+	}
+	// 16个令牌, 3个突发, 其余13个按每10ms一个
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("16 tokens at 100/s took %v", d)
+	}
+}
+
+func TestRedisGCRAInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -5} {
+		if g, err := NewRedisGCRA(nil, rate, 1); g != nil || err != ErrInvalidRate {
+			t.Fatalf("rate %v: %v", rate, err)
+		}
+	}
+}
+
+// 存储异常时放行, 结果格式不对时返回错误
+func TestDistributedLimiterError(t *testing.T) {
+	failing := ScriptRunnerFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+	g, _ := NewRedisGCRA(failing, 1, 1)
+	if err := NewDistributedLimiter(g, "k").Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := ScriptRunnerFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		return "OK", nil
+	})
+	g, _ = NewRedisGCRA(bad, 1, 1)
+	if _, _, err := g.Allow(context.Background(), "k", 1); err == nil {
+		t.Fatal("unexpected result accepted")
+	}
+}
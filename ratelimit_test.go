@@ -0,0 +1,81 @@
+package job
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b, _ := NewTokenBucket(100, 5)
+	ctx := context.Background()
+
+	// 初始积攒burst个令牌, 不需要等待
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := b.Wait(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 20*time.Millisecond {
+		t.Fatalf("burst waited %v", d)
+	}
+
+	// 之后每秒100个, 10个令牌约100ms
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		b.Wait(ctx, 1)
+	}
+	if d := time.Since(start); d < 80*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("10 tokens at 100/s took %v", d)
+	}
+}
+
+func TestTokenBucketCancel(t *testing.T) {
+	b, _ := NewTokenBucket(0.1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	b.Wait(ctx, 1)
+	if err := b.Wait(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenBucketInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		if l, err := NewTokenBucket(rate, 1); l != nil || err != ErrInvalidRate {
+			t.Fatalf("rate %v: %v", rate, err)
+		}
+	}
+}
+
+// topic限流后任务按速率执行, 并记录被限流的次数
+func TestSetRateLimit(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	var done int64
+	j.AddFunc(q, "r", func(ctx context.Context, task *Task) { atomic.AddInt64(&done, 1) }, 4)
+	if err := j.SetRateLimit("r", 50, 1); err != nil {
+		t.Fatal(err)
+	}
+	j.BatchEnqueue(context.Background(), "r", []string{"a", "b", "c", "d", "e", "f"})
+	start := time.Now()
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&done) == 6 })
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("6 tasks at 50/s took %v", d)
+	}
+	if stats, _ := j.TopicStats("r"); stats["throttled"] == 0 {
+		t.Fatal(stats)
+	}
+
+	// rate<=0取消限流
+	j.SetRateLimit("r", 0, 0)
+	w, _ := j.getWorker("r")
+	if w.rateLimiter() != nil {
+		t.Fatal("rate limit not removed")
+	}
+}
@@ -0,0 +1,113 @@
+package job
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	//信封头：签名使用的密钥id
+	HeaderSignKeyId = "sign-key-id"
+	//信封头：HMAC-SHA256签名，base64编码
+	HeaderSignature = "signature"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid task signature")
+)
+
+// 签名密钥
+type SigningKey struct {
+	Id  string
+	Key []byte
+}
+
+// HMAC-SHA256签名密钥集合, 第一个密钥用于签名, 所有密钥都可以用于验签
+// 轮换时先把新密钥添加到消费端, 再设置为第一个, 旧消息消费完后删除旧密钥
+type HMACKeys struct {
+	mu   sync.RWMutex
+	keys []SigningKey
+}
+
+func NewHMACKeys(keys ...SigningKey) *HMACKeys {
+	k := new(HMACKeys)
+	k.Set(keys...)
+	return k
+}
+
+// 替换全部密钥
+func (k *HMACKeys) Set(keys ...SigningKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = append([]SigningKey(nil), keys...)
+}
+
+func (k *HMACKeys) signingKey() (SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) == 0 {
+		return SigningKey{}, false
+	}
+	return k.keys[0], true
+}
+
+func (k *HMACKeys) key(id string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.Id == id {
+			return key.Key, true
+		}
+	}
+	return nil, false
+}
+
+// 签名内容: topic + 不含签名的信封, 签名在最后执行, 覆盖所有头信息和加密后的消息体
+func signature(key []byte, topic string, e *envelope) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(appendBytes(nil, []byte(topic)))
+	mac.Write(e.marshal())
+	return mac.Sum(nil)
+}
+
+// 返回签名信封的函数, keys为nil时不签名
+func sign(topic string, keys *HMACKeys) envelopeFunc {
+	return func(e *envelope) error {
+		if keys == nil {
+			return nil
+		}
+		key, ok := keys.signingKey()
+		if !ok {
+			return fmt.Errorf("%w: signing key is not set", ErrInvalidSignature)
+		}
+		e.set(HeaderSignKeyId, key.Id)
+		e.set(HeaderSignature, base64.StdEncoding.EncodeToString(signature(key.Key, topic, e)))
+		return nil
+	}
+}
+
+// 验证签名并删除签名头, 没有签名或者签名不正确时返回的错误包装了ErrInvalidSignature
+func verifyEnvelope(e *envelope, topic string, keys *HMACKeys) error {
+	sig := e.get(HeaderSignature)
+	if sig == "" {
+		return fmt.Errorf("%w: unsigned message", ErrInvalidSignature)
+	}
+	mac, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	key, ok := keys.key(e.get(HeaderSignKeyId))
+	if !ok {
+		return fmt.Errorf("%w: unknown key %s", ErrInvalidSignature, e.get(HeaderSignKeyId))
+	}
+	delete(e.headers, HeaderSignature)
+	if !hmac.Equal(mac, signature(key, topic, e)) {
+		return ErrInvalidSignature
+	}
+	delete(e.headers, HeaderSignKeyId)
+	return nil
+}
@@ -0,0 +1,117 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func signedMessage(t *testing.T, keys *HMACKeys, task Task) []byte {
+	t.Helper()
+	b, err := encodeTask(&task, JSONCodec{}, sign("t", keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// 轮换密钥: 新密钥签名, 旧密钥仍可以验签, 删除旧密钥后旧消息验签失败
+func TestSignRotation(t *testing.T) {
+	k1 := SigningKey{Id: "k1", Key: []byte("secret-1")}
+	k2 := SigningKey{Id: "k2", Key: []byte("secret-2")}
+	keys := NewHMACKeys(k1)
+	task := GenTask("t", "m")
+	old := signedMessage(t, keys, task)
+	keys.Set(k2, k1)
+	cur := signedMessage(t, keys, task)
+
+	opts := decodeOptions{topic: "t", signing: keys}
+	for _, b := range [][]byte{old, cur} {
+		got, err := decodeTask(b, opts)
+		if err != nil || got.Id != task.Id {
+			t.Fatal(err)
+		}
+	}
+	// 不验签时可以直接解码
+	if got, err := DecodeBytesTask(cur); err != nil || got.Id != task.Id {
+		t.Fatal(err)
+	}
+	keys.Set(k2)
+	if _, err := decodeTask(old, opts); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal(err)
+	}
+	if _, err := encodeTask(&task, JSONCodec{}, sign("t", NewHMACKeys())); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal(err)
+	}
+}
+
+func TestSignReject(t *testing.T) {
+	keys := NewHMACKeys(SigningKey{Id: "k1", Key: []byte("secret")})
+	task := GenTask("t", "m")
+	b := signedMessage(t, keys, task)
+	plain, _ := encodeTask(&task, JSONCodec{})
+	unsigned, _ := encodeTask(&task, MsgpackCodec{})
+
+	tampers := map[string]func(e *envelope){
+		"header":    func(e *envelope) { e.set(HeaderContentType, ContentTypeMsgpack) },
+		"body":      func(e *envelope) { e.body[len(e.body)-2] ^= 1 },
+		"signature": func(e *envelope) { e.set(HeaderSignature, "!") },
+		"key":       func(e *envelope) { e.set(HeaderSignKeyId, "k2") },
+	}
+	cases := map[string][]byte{"plain": plain, "unsigned": unsigned}
+	for name, tamper := range tampers {
+		e, _ := unmarshalEnvelope(append([]byte(nil), b...))
+		tamper(e)
+		cases[name] = e.marshal()
+	}
+	for name, m := range cases {
+		if _, err := decodeTask(m, decodeOptions{topic: "t", signing: keys}); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatal(name, err)
+		}
+	}
+	if _, err := decodeTask(b, decodeOptions{topic: "other", signing: keys}); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal("topic", err)
+	}
+}
+
+// 签名的消息正常执行, 没有签名的消息进入死信队列
+func TestSignWorker(t *testing.T) {
+	keys := NewHMACKeys(SigningKey{Id: "k1", Key: []byte("secret")})
+	q := newMemQueue()
+	j := New()
+	j.AddQueue(q)
+	var done int64
+	j.AddFunc(q, "t", func(ctx context.Context, task *Task) { atomic.AddInt64(&done, 1) }, 1)
+	j.SetDeadLetter("t", "t:dead")
+	q.Enqueue(context.Background(), "t", GenTask("t", "unsigned").String())
+	j.SetSigning(keys, "t")
+	if ok, err := j.Enqueue(context.Background(), "t", "signed"); !ok || err != nil {
+		t.Fatal(err)
+	}
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 2*time.Second, func() bool { return q.len("t:dead") == 1 && atomic.LoadInt64(&done) == 1 })
+	if stats, _ := j.TopicStats("t"); stats["signature_err"] != 1 {
+		t.Fatal(stats)
+	}
+}
+
+// 没有设置死信topic时签名不正确的消息直接ack丢弃, 不执行
+func TestSignRejectNoDeadLetter(t *testing.T) {
+	keys := NewHMACKeys(SigningKey{Id: "k1", Key: []byte("secret")})
+	q := newMemQueue()
+	j := New()
+	j.AddFunc(q, "t", func(ctx context.Context, task *Task) { t.Error("unsigned executed") }, 1)
+	q.Enqueue(context.Background(), "t", GenTask("t", "unsigned").String())
+	j.SetSigning(keys, "t")
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&q.acks) == 1 })
+	if stats, _ := j.TopicStats("t"); stats["signature_err"] != 1 {
+		t.Fatal(stats)
+	}
+}
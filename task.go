@@ -2,6 +2,7 @@ package job
 
 import (
 	"encoding/json"
+	"time"
 )
 
 const (
@@ -16,17 +17,63 @@ const (
 )
 
 type Task struct {
-	Id           string `json:"id"`
-	Topic        string `json:"topic"`
-	Message      string `json:"message"`
-	Token        string
-	DequeueCount int64
-	Result       Result
+	Id      string `json:"id"`
+	Topic   string `json:"topic"`
+	Message string `json:"message"`
+	//二进制消息，与Message二选一，JSON编码为base64，二进制编解码器解码时可能引用出队的缓冲区，需要保留时先拷贝
+	Payload []byte `json:"payload,omitempty"`
+	//分区key，相同key的任务按顺序串行执行，为空则不保证顺序
+	PartitionKey string `json:"partition_key,omitempty"`
+	//自定义头信息，如链路追踪、租户、请求id等，随任务编码传递到消费端
+	Headers map[string]string `json:"headers,omitempty"`
+	//入队时间，毫秒时间戳，入队时自动设置
+	EnqueuedAt int64 `json:"enqueued_at,omitempty"`
+	//第一次执行时间，毫秒时间戳，消费端拉取到任务准备执行时设置（已有值时不覆盖）
+	//只有通过EnqueueWithTask重新入队或者熔断延迟重新入队时随任务保留，queue服务重放的消息没有该值
+	FirstAttemptAt int64 `json:"first_attempt_at,omitempty"`
+	//最早执行时间，毫秒时间戳，在此之前拉取到的任务保留在消费端本地，到时间后再执行，期间不ack
+	NotBefore int64 `json:"not_before,omitempty"`
+	//大消息的存储引用，消息超过阈值时入队前存入ClaimStore并清空消息，执行前自动取回，ack后删除
+	ClaimRef string `json:"claim_ref,omitempty"`
+
+	//以下为消费端状态，由队列驱动和任务执行结果设置
+	Token        string `json:"token,omitempty"`
+	DequeueCount int64  `json:"dequeue_count,omitempty"`
+	Result       Result `json:"result"`
 }
 
 type Result struct {
-	State   int
-	Message string
+	State   int    `json:"state"`
+	Message string `json:"message,omitempty"`
+}
+
+func (t *Task) GetHeader(key string) string {
+	return t.Headers[key]
+}
+
+func (t *Task) SetHeader(key, value string) {
+	if t.Headers == nil {
+		t.Headers = make(map[string]string)
+	}
+	t.Headers[key] = value
+}
+
+// 消息内容，优先返回Payload
+func (t *Task) Body() []byte {
+	if t.Payload != nil {
+		return t.Payload
+	}
+	return []byte(t.Message)
+}
+
+// 设置最早执行时间
+func (t *Task) SetNotBefore(at time.Time) {
+	t.NotBefore = unixMilli(at)
+}
+
+// 任务是否还没到最早执行时间
+func (t *Task) Deferred() bool {
+	return t.NotBefore > 0 && unixMilli(time.Now()) < t.NotBefore
 }
 
 func (t Task) String() string {
@@ -38,16 +85,22 @@ func (t Task) Bytes() []byte {
 	return bytes
 }
 
+//解码任务，消息为string时使用，二进制消息使用DecodeBytesTask
 func DecodeStringTask(s string) (t Task, err error) {
 	t, err = DecodeBytesTask([]byte(s))
 	return
 }
 
+//解码任务，带信封的消息自动解压并按content-type选择编解码器，否则按JSON解码
+//加密的消息返回ErrDecryptFailed，由worker按topic的密钥解密
 func DecodeBytesTask(b []byte) (t Task, err error) {
-	err = json.Unmarshal(b, &t)
-	return
+	return decodeTask(b, decodeOptions{})
 }
 
 func GenTask(topic string, message string) Task {
 	return Task{Id: GenUUID(), Topic: topic, Message: message}
 }
+
+func GenBytesTask(topic string, payload []byte) Task {
+	return Task{Id: GenUUID(), Topic: topic, Payload: payload}
+}
@@ -0,0 +1,118 @@
+package job
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 未到最早执行时间的任务保留在本地, 到时间后执行并ack
+func TestNotBefore(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	executed := make(chan *Task, 1)
+	j.AddFunc(q, "d", func(ctx context.Context, task *Task) { executed <- task }, 1)
+	task := GenTask("d", "m")
+	task.SetHeader("trace", "t1")
+	task.SetNotBefore(time.Now().Add(150 * time.Millisecond))
+	start := time.Now()
+	j.EnqueueWithTask(context.Background(), "d", task)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, time.Second, func() bool { return q.len("d") == 0 })
+	if stats, _ := j.TopicStats("d"); stats["deferred"] != 1 || stats["delayed"] != 1 {
+		t.Fatal(stats)
+	}
+	if atomic.LoadInt64(&q.acks) != 0 {
+		t.Fatal("deferred task acked before execution")
+	}
+
+	select {
+	case got := <-executed:
+		if d := time.Since(start); d < 140*time.Millisecond {
+			t.Fatalf("executed after %v", d)
+		}
+		if got.GetHeader("trace") != "t1" || got.EnqueuedAt == 0 || got.FirstAttemptAt < got.NotBefore {
+			t.Fatalf("task %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("deferred task not executed")
+	}
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt64(&q.acks) == 1 })
+}
+
+// 暂停时到期的任务等到恢复后再执行
+func TestNotBeforePaused(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	var done int64
+	j.AddFunc(q, "d", func(ctx context.Context, task *Task) { atomic.AddInt64(&done, 1) }, 1)
+	task := GenTask("d", "m")
+	task.SetNotBefore(time.Now().Add(50 * time.Millisecond))
+	j.EnqueueWithTask(context.Background(), "d", task)
+	j.Start()
+	defer stopJob(t, j)
+
+	waitFor(t, time.Second, func() bool { return q.len("d") == 0 })
+	j.Pause("d")
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&done) != 0 {
+		t.Fatal("executed while paused")
+	}
+	j.Resume("d")
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt64(&done) == 1 })
+}
+
+// 本地保留的延迟任务达到上限后暂停拉取
+func TestDelayBuffer(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	var done int64
+	j.AddFunc(q, "d", func(ctx context.Context, task *Task) { atomic.AddInt64(&done, 1) }, 1)
+	w, _ := j.getWorker("d")
+	w.SetDelayBuffer(2)
+	tasks := make([]Task, 5)
+	for i := range tasks {
+		tasks[i] = GenTask("d", "m")
+		tasks[i].SetNotBefore(time.Now().Add(100 * time.Millisecond))
+	}
+	j.BatchEnqueueWithTask(context.Background(), "d", tasks)
+	j.Start()
+	defer stopJob(t, j)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := q.len("d"); n != 3 {
+		t.Fatalf("%d tasks left in queue, want 3", n)
+	}
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&done) == 5 })
+}
+
+// 重新入队时保留第一次执行时间和头信息, 到时间后再次执行
+func TestRequeueKeepsFirstAttempt(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	executed := make(chan *Task, 1)
+	j.AddFunc(q, "d", func(ctx context.Context, task *Task) { executed <- task }, 1)
+	w, _ := j.getWorker("d")
+
+	task := GenTask("d", "m")
+	task.Token = "tok"
+	task.FirstAttemptAt = unixMilli(time.Now().Add(-time.Hour))
+	task.SetHeader("trace", "t1")
+	if !w.requeue(&task, time.Now().Add(50*time.Millisecond)) {
+		t.Fatal("requeue failed")
+	}
+	j.Start()
+	defer stopJob(t, j)
+
+	select {
+	case got := <-executed:
+		if got.Id != task.Id || got.FirstAttemptAt != task.FirstAttemptAt || got.GetHeader("trace") != "t1" {
+			t.Fatalf("task %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("requeued task not executed")
+	}
+}
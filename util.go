@@ -3,6 +3,7 @@ package job
 import (
 	"encoding/json"
 	"github.com/google/uuid"
+	"time"
 )
 
 
@@ -18,3 +19,8 @@ func GenUUID() string {
 	u, _ := uuid.NewRandom()
 	return u.String()
 }
+
+//毫秒时间戳
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -2,10 +2,12 @@ package job
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/navi-tt/job/internal/log"
 	"github.com/navi-tt/job/internal/queue"
 	"github.com/panjf2000/ants/v2"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -34,25 +36,63 @@ type WorkerWithFunc struct {
 	worker Worker // 任务执行器
 
 	extra   []interface{} // 为了一些特殊驱动需要额外参数
-	working bool          // 是否工作中
+	working int32         // 是否工作中
 	pipe    chan *Task    // 队列拉取任务协程与执行任务协程直接通信管道
+	pipeMu  sync.RWMutex  // 保护pipe的替换和关闭
+	// 被替换的pipe到替换它的pipe, 执行协程读完旧pipe后按顺序切换, 连续调整多次也不会跳过中间的pipe
+	pipeNext map[chan *Task]chan *Task
+
+	emptyBackoff Backoff // 队列为空时的退避, 每个worker独立
+	errBackoff   Backoff // 拉取异常时的退避, 每个worker独立
+
+	acker *ackBatcher // 批量ack聚合器, 未开启批量ack时为nil
+
+	batchWorker BatchWorker   // 批量任务执行器, 不为nil时代替worker
+	batchSize   int           // 每批最多任务数
+	linger      time.Duration // 凑批的最长等待时间
+
+	partitions *partitioner // 按分区key串行执行
+
+	paused   int32 // 是否暂停拉取新任务
+	pulling  int32 // 拉取协程是否正在拉取任务
+	inflight int64 // 已放入pipe但还未处理完成的任务数
+
+	limiter        atomic.Value // 限流器
+	throttledCount int64        // 被限流阻塞的次数
+	throttledTime  int64        // 被限流阻塞的总时间(纳秒)
+
+	concurrencyLimiter atomic.Value // 自适应并发控制
+
+	breaker         atomic.Value // 熔断
+	breakerRejected int64        // 熔断拒绝执行的任务数
+	deferredCount   int64        // 未到最早执行时间的任务数
+	delayed         int64        // 保留在本地等待最早执行时间的任务数
+	delayBuffer     int64        // 本地最多保留的延迟任务数, 达到后暂停拉取
+
+	deadLetter        atomic.Value // 死信topic, 无法处理的消息转入该topic
+	decryptErrCount   int64        // 解密失败的消息数
+	signatureErrCount int64        // 没有签名或签名不正确的消息数
+
+	middlewares []Middleware // worker的中间件
+	mwMu        sync.Mutex
+	handler     atomic.Value // 经过中间件包装后的任务执行器
 }
 
-func validate(q queue.Queue, topic string, f func(context.Context, *Task)) error {
+func validate(q queue.Queue, topic string, hasFunc bool) error {
 	if q == nil {
 		return fmt.Errorf("queue.Queue can not be nil")
 	}
 	if len(topic) == 0 {
 		return fmt.Errorf("topic can not be\"\"")
 	}
-	if f == nil {
+	if !hasFunc {
 		return fmt.Errorf("work func can not be nil")
 	}
 	return nil
 }
 
 func (j *Job) NewWorkerWithFunc(q queue.Queue, topic string, f func(context.Context, *Task), size int, extra ...interface{}) (*WorkerWithFunc, error) {
-	err := validate(q, topic, f)
+	err := validate(q, topic, f != nil)
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +101,18 @@ func (j *Job) NewWorkerWithFunc(q queue.Queue, topic string, f func(context.Cont
 		size = defaultConcurrency
 	}
 
+	w, err := j.newWorker(q, topic, size, size, extra)
+	if err != nil {
+		return nil, err
+	}
+	w.worker = WorkerFunc(f)
+
+	return w, nil
+}
+
+// size为协程池大小, pipeSize为拉取任务的缓冲大小
+func (j *Job) newWorker(q queue.Queue, topic string, size int, pipeSize int, extra []interface{}) (*WorkerWithFunc, error) {
+	var err error
 	w := new(WorkerWithFunc)
 	w.job = j
 	w.q = q
@@ -70,10 +122,11 @@ func (j *Job) NewWorkerWithFunc(q queue.Queue, topic string, f func(context.Cont
 		return nil, err
 	}
 
-	w.worker = WorkerFunc(f)
 	w.extra = extra
-	w.working = true
-	w.pipe = make(chan *Task, size)
+	w.working = 1
+	w.partitions = newPartitioner(defaultPartitionBuffer)
+	w.delayBuffer = defaultDelayBuffer
+	w.pipe = make(chan *Task, pipeSize)
 
 	return w, nil
 }
@@ -98,26 +151,65 @@ func (w *WorkerWithFunc) Worker() Worker {
 	return w.worker
 }
 
+//设置该worker队列为空时的退避策略, 需要在Run之前设置, 否则使用Job的配置
+func (w *WorkerWithFunc) SetEmptyBackoff(b Backoff) {
+	w.emptyBackoff = b
+}
+
+//设置该worker拉取异常时的退避策略, 需要在Run之前设置, 否则使用Job的配置
+func (w *WorkerWithFunc) SetErrorBackoff(b Backoff) {
+	w.errBackoff = b
+}
+
+func (w *WorkerWithFunc) isWorking() bool {
+	return atomic.LoadInt32(&w.working) == 1
+}
+
 func (w *WorkerWithFunc) Close() {
-	w.working = false
+	w.pipeMu.Lock()
+	if !w.isWorking() {
+		w.pipeMu.Unlock()
+		return
+	}
+	atomic.StoreInt32(&w.working, 0)
 	close(w.pipe)
+	w.pipeMu.Unlock()
+	if w.acker != nil {
+		w.acker.close()
+	}
 }
 
 func (w *WorkerWithFunc) Run() {
-	// 开启拉取队列数据
-	w.pullTask()
+	w.start()
+	if w.batchWorker != nil {
+		go w.runBatch()
+		return
+	}
 	go func() {
-		for w.Job().running && w.working {
+		pipe := w.getPipe()
+		for w.Job().IsRunning() && w.isWorking() {
 			select {
 			// 阻塞处理队列 task 任务
-			case task, ok := <-w.pipe:
+			case task, ok := <-pipe:
 				if !ok {
+					// pipe被调整大小, 旧pipe中的任务已经读完, 切换到替换它的pipe
+					if np := w.nextPipe(pipe); np != nil {
+						pipe = np
+						continue
+					}
 					return // channel pipe关闭, 即工作任务被关闭, 结束该协程
 				}
 
+				// 同一分区key的任务已经在执行, 放入缓冲等待串行执行
+				if task.PartitionKey != "" && !w.partitions.acquire(task) {
+					continue
+				}
+
 				// 协程池执行任务, 协程池可用协程为空则阻塞在此处
-				if err := w.Submit(func() { w.processTask(task) }); err != nil {
+				if err := w.Submit(func() { w.processOrdered(task) }); err != nil {
+					atomic.AddInt64(&w.inflight, -1)
 					log.Error(err)
+					w.releasePartition(task)
 					continue
 				}
 			}
@@ -125,84 +217,296 @@ func (w *WorkerWithFunc) Run() {
 	}()
 }
 
+// 初始化退避和ack聚合器, 并开启拉取队列数据
+func (w *WorkerWithFunc) start() {
+	if w.emptyBackoff == nil {
+		w.emptyBackoff = w.Job().emptyBackoff()
+	}
+	if w.errBackoff == nil {
+		w.errBackoff = w.Job().errBackoff()
+	}
+	w.buildHandler()
+	if bq, ok := w.Queue().(queue.BatchAckQueue); ok && w.Job().ackBatchSize > 1 && w.acker == nil {
+		w.acker = newAckBatcher(w, bq, w.Job().ackBatchSize, w.Job().ackInterval)
+		go w.acker.run()
+	}
+	// 开启拉取队列数据
+	w.pullTask()
+}
+
 func (w *WorkerWithFunc) pullTask() {
 	go func() {
-		for w.Job().running && w.working {
-			// todo(liuxp: 考虑将出队和反序列化task任务的逻辑, 用协程处理, 提高出队效率)
-			// todo(liuxp: 或考虑将Dequeue设计为阻塞, 但是性能可能不好)
-			message, token, dequeueCount, err := w.Queue().Dequeue(w.Job().ctx, w.Topic(), w.Extra())
-			atomic.AddInt64(&w.Job().pullCount, 1)
-			if err != nil && err != queue.ErrNil {
-				atomic.AddInt64(&w.Job().pullErrCount, 1)
-				log.Errorf("dequeue_error: %v, %v", err, message)
-				w.Job().Sleep()
+		for w.Job().IsRunning() && w.isWorking() {
+			// 先标记拉取中再检查暂停状态, 保证Drain不会漏掉正在拉取的任务
+			atomic.StoreInt32(&w.pulling, 1)
+			limit := 0
+			if !w.Paused() && atomic.LoadInt64(&w.delayed) < w.delayBuffer {
+				limit = w.breakerPullable()
+			}
+			if limit == 0 {
+				atomic.StoreInt32(&w.pulling, 0)
+				time.Sleep(w.Job().timer)
 				continue
 			}
+			ok := w.pullOnce(limit)
+			atomic.StoreInt32(&w.pulling, 0)
+			if !ok {
+				return
+			}
+		}
+	}()
+}
+
+// 拉取一次最多limit个任务并放入pipe, limit小于0表示不限制, 服务停止时返回false
+func (w *WorkerWithFunc) pullOnce(limit int) bool {
+	// todo(liuxp: 考虑将出队和反序列化task任务的逻辑, 用协程处理, 提高出队效率)
+	messages, blocked, err := w.dequeue(limit)
+	atomic.AddInt64(&w.Job().pullCount, 1)
+	if err != nil && err != queue.ErrNil {
+		atomic.AddInt64(&w.Job().pullErrCount, 1)
+		log.Errorf("dequeue_error: %v, %v", err, messages)
+		time.Sleep(w.errBackoff.Next())
+		return true
+	}
+
+	if len(messages) == 0 {
+		atomic.AddInt64(&w.Job().pullEmptyCount, 1)
+		w.errBackoff.Reset()
+		if !blocked {
+			// 阻塞出队已经在队列服务端等待过, 不需要再休眠
+			time.Sleep(w.emptyBackoff.Next())
+		}
+		return true
+	}
+	w.emptyBackoff.Reset()
+	w.errBackoff.Reset()
 
-			if err == queue.ErrNil || message == "" {
-				atomic.AddInt64(&w.Job().pullEmptyCount, 1)
-				w.Job().Sleep()
+	for _, m := range messages {
+		atomic.AddInt64(&w.Job().taskCount, 1)
+		t, err := w.decodeMessage(m)
+		if err != nil {
+			atomic.AddInt64(&w.Job().taskErrCount, 1)
+			if errors.Is(err, ErrInvalidSignature) {
+				atomic.AddInt64(&w.signatureErrCount, 1)
+				w.reject(m, err)
 				continue
 			}
-			atomic.AddInt64(&w.Job().taskCount, 1)
-
-			t, err := DecodeStringTask(message)
-			if err != nil {
-				atomic.AddInt64(&w.Job().taskErrCount, 1)
-				log.Errorf("decode_task_error: %v, %v", err, message)
-				w.Job().Sleep()
+			if errors.Is(err, ErrDecryptFailed) {
+				atomic.AddInt64(&w.decryptErrCount, 1)
+				w.reject(m, err)
 				continue
-			} else if t.Topic != "" {
-				t.Token = token
 			}
-			t.DequeueCount = dequeueCount
-			w.Job().ResetSleep()
-
-			//w.pipe <- &t
-			select {
-			case w.pipe <- &t:
-			default:
-				// 这里开select是为了该协程不会因为pipe阻塞而收不到wp的Close信号
-				// 但是可能导致wStop后没有把当前的task传给pipe, 导致一次数据丢失
-				push := false
-				for !push {
-					if w.Job().running && w.working {
-						return
-					}
-					ticker := time.NewTicker(w.Job().timer)
-					select {
-					case <-ticker.C:
-					case w.pipe <- &t:
-						push = true
-					}
-					ticker.Stop()
-				}
+			if errors.Is(err, ErrDecompressedTooLarge) {
+				w.reject(m, err)
+				continue
+			}
+			if m.Body != nil {
+				m.Message = string(m.Body)
 			}
+			log.Errorf("decode_task_error: %v, %v", err, m.Message)
+			continue
+		} else if t.Topic != "" {
+			t.Token = m.Token
 		}
-	}()
+		t.DequeueCount = m.DequeueCount
+		if t.Deferred() {
+			// 还没到最早执行时间, 保留在本地, 到时间后再放入pipe, 期间不ack
+			atomic.AddInt64(&w.deferredCount, 1)
+			w.delay(&t)
+			continue
+		}
+		if !w.dispatch(&t) {
+			return false
+		}
+	}
+	return true
+}
+
+// 获取令牌后放入pipe, 服务停止时返回false
+func (w *WorkerWithFunc) dispatch(t *Task) bool {
+	if t.FirstAttemptAt == 0 {
+		t.FirstAttemptAt = unixMilli(time.Now())
+	}
+	if err := w.waitRateLimit(); err != nil {
+		log.Errorf("rate_limit_error: %v, %v", err, w.Topic())
+	}
+	return w.push(t)
+}
+
+// 设置本地最多保留的延迟任务数, 达到后暂停拉取直到有任务到期, 需要在Run之前设置
+func (w *WorkerWithFunc) SetDelayBuffer(n int) {
+	if n <= 0 {
+		n = defaultDelayBuffer
+	}
+	w.delayBuffer = int64(n)
+}
+
+// 保留任务到最早执行时间后放入pipe, 暂停时等到恢复后再放入
+// 服务停止或worker关闭时丢弃, 任务没有ack, 如果queue服务支持, 会进行消息重放
+// queue服务有可见性超时(如SQS)时, 延迟超过可见性超时的任务会被重复投递
+func (w *WorkerWithFunc) delay(t *Task) {
+	atomic.AddInt64(&w.delayed, 1)
+	var fire func()
+	fire = func() {
+		if !w.Job().IsRunning() || !w.isWorking() {
+			atomic.AddInt64(&w.delayed, -1)
+			return
+		}
+		if w.Paused() {
+			time.AfterFunc(w.Job().timer, fire)
+			return
+		}
+		atomic.AddInt64(&w.delayed, -1)
+		w.dispatch(t)
+	}
+	time.AfterFunc(time.Until(time.Unix(0, t.NotBefore*int64(time.Millisecond))), fire)
+}
+
+// 将任务放入pipe, pipe满时按timer周期检查运行状态, 服务停止时返回false
+// 服务停止时未放入pipe的任务没有ack, 如果queue服务支持, 会进行消息重放
+func (w *WorkerWithFunc) push(t *Task) bool {
+	var ticker *time.Ticker
+	for {
+		w.pipeMu.RLock()
+		if !w.Job().IsRunning() || !w.isWorking() {
+			w.pipeMu.RUnlock()
+			return false
+		}
+		select {
+		case w.pipe <- t:
+			atomic.AddInt64(&w.inflight, 1)
+			w.pipeMu.RUnlock()
+			return true
+		default:
+		}
+		if ticker == nil {
+			ticker = time.NewTicker(w.Job().timer)
+			defer ticker.Stop()
+		}
+		// 持有读锁最多一个timer周期, 调整pipe大小和关闭时最多等待一个周期
+		select {
+		case w.pipe <- t:
+			atomic.AddInt64(&w.inflight, 1)
+			w.pipeMu.RUnlock()
+			return true
+		case <-ticker.C:
+		}
+		w.pipeMu.RUnlock()
+	}
+}
+
+func (w *WorkerWithFunc) getPipe() chan *Task {
+	w.pipeMu.RLock()
+	defer w.pipeMu.RUnlock()
+	return w.pipe
+}
+
+// 返回替换已关闭pipe的pipe, pipe是被Close关闭的则返回nil
+func (w *WorkerWithFunc) nextPipe(pipe chan *Task) chan *Task {
+	w.pipeMu.Lock()
+	defer w.pipeMu.Unlock()
+	np := w.pipeNext[pipe]
+	delete(w.pipeNext, pipe)
+	return np
+}
+
+// 出队, 优先批量出队, 数量为pipe剩余容量(即worker并发数减去未处理的任务数), 且不超过limit
+// 队列为空时如果支持阻塞出队则阻塞等待, blocked表示本次是否为阻塞出队
+func (w *WorkerWithFunc) dequeue(limit int) (messages []queue.Message, blocked bool, err error) {
+	ctx := w.Job().ctx
+	bq, batch := w.Queue().(queue.BatchQueue)
+	if batch {
+		pipe := w.getPipe()
+		n := cap(pipe) - len(pipe)
+		if limit > 0 && n > limit {
+			n = limit
+		}
+		if n < 1 {
+			n = 1
+		}
+		messages, err = bq.BatchDequeue(ctx, w.Topic(), n, w.Extra())
+		if (err != nil && err != queue.ErrNil) || len(messages) > 0 {
+			return messages, false, err
+		}
+	}
+
+	var m queue.Message
+	if lq, ok := w.Queue().(queue.BlockingQueue); ok && w.Job().blockTimeout > 0 {
+		m.Message, m.Token, m.DequeueCount, err = lq.BlockingDequeue(ctx, w.Topic(), w.Job().blockTimeout, w.Extra())
+		blocked = true
+	} else if batch {
+		return nil, false, queue.ErrNil
+	} else if bq, ok := w.Queue().(queue.BytesQueue); ok {
+		m.Body, m.Token, m.DequeueCount, err = bq.DequeueBytes(ctx, w.Topic(), w.Extra())
+	} else {
+		m.Message, m.Token, m.DequeueCount, err = w.Queue().Dequeue(ctx, w.Topic(), w.Extra())
+	}
+	if m.Message != "" || len(m.Body) > 0 {
+		messages = []queue.Message{m}
+	}
+	return messages, blocked, err
+}
+
+// 解码出队的消息, 二进制消息不经过string转换, 使用topic的密钥验签和解密
+func (w *WorkerWithFunc) decodeMessage(m queue.Message) (Task, error) {
+	p := w.Job().producer
+	opts := decodeOptions{
+		topic:   w.Topic(),
+		keys:    p.getKeyProvider(w.Topic()),
+		signing: p.getSigning(w.Topic()),
+	}
+	if m.Body != nil {
+		return decodeTask(m.Body, opts)
+	}
+	return decodeTask([]byte(m.Message), opts)
 }
 
 func (w *WorkerWithFunc) processTask(task *Task) {
+	if !w.breakerAllow(task) {
+		atomic.AddInt64(&w.inflight, -1)
+		return
+	}
 	w.Job().wg.Add(1)
+	start := time.Now()
 	defer func() {
 		w.Job().wg.Done()
+		atomic.AddInt64(&w.inflight, -1)
 		//任务panic回调函数
-		if e := recover(); e != nil {
-			atomic.AddInt64(&w.Job().handlePanicCount, 1)
-			if w.Job().taskPanicCallback != nil {
-				w.Job().taskPanicCallback(task, e)
-			} else {
-				log.Error("task_panic", task, e)
-			}
+		e := recover()
+		if e != nil {
+			w.taskPanic(task, e)
 		}
+		failed := e != nil || taskFailed(task)
+		w.observe(time.Since(start), failed)
+		w.breakerDone(failed)
 	}()
 
+	//取回存入ClaimStore的消息体, 失败时不执行
+	if !w.resolveClaim(task) {
+		w.finishTask(task)
+		return
+	}
+
 	//任务处理前回调函数
 	if w.Job().taskBeforeCallback != nil {
 		w.Job().taskBeforeCallback(task)
 	}
 
-	w.Worker().Exec(w.Job().ctx, task)
+	w.getHandler().Exec(w.Job().ctx, task)
+	w.finishTask(task)
+}
+
+func (w *WorkerWithFunc) taskPanic(task *Task, e interface{}) {
+	atomic.AddInt64(&w.Job().handlePanicCount, 1)
+	if w.Job().taskPanicCallback != nil {
+		w.Job().taskPanicCallback(task, e)
+	} else {
+		log.Error("task_panic", task, e)
+	}
+}
+
+// 根据任务结果统计、ack并执行处理后回调
+func (w *WorkerWithFunc) finishTask(task *Task) {
 	result := task.Result
 
 	atomic.AddInt64(&w.Job().handleCount, 1)
@@ -219,11 +523,11 @@ func (w *WorkerWithFunc) processTask(task *Task) {
 		atomic.AddInt64(&w.Job().handleErrCount, 1)
 	}
 
-	//消息ACK
+	//消息ACK, 开启批量ack时交给聚合器异步提交
 	if isAck && task.Token != "" {
-		_, err := w.Queue().AckMsg(w.Job().ctx, w.Topic(), task.Token, w.Extra())
-		if err != nil {
-			log.Error("ack_error", w.Topic(), task)
+		if w.acker != nil {
+			w.acker.add(task)
+		} else if !w.ack(task) {
 			return
 		}
 	}
@@ -232,3 +536,20 @@ func (w *WorkerWithFunc) processTask(task *Task) {
 		w.Job().taskAfterCallback(task)
 	}
 }
+
+// 任务延迟到notBefore后重新入队, 成功后ack当前消息, 当前消息存入ClaimStore的消息体由新消息继续引用, 不删除
+// 重新入队失败时不ack, 如果queue服务支持, 会进行消息重放
+func (w *WorkerWithFunc) requeue(task *Task, notBefore time.Time) bool {
+	t := *task
+	t.Token, t.DequeueCount, t.Result = "", 0, Result{}
+	t.SetNotBefore(notBefore)
+	ok, err := w.Job().producer.EnqueueWithTask(w.Job().ctx, w.Topic(), t)
+	if err != nil || !ok {
+		log.Errorf("requeue_error: %v, %v", err, task.Id)
+		return false
+	}
+	if task.Token != "" {
+		w.ack(&Task{Id: task.Id, Topic: task.Topic, Token: task.Token})
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package job
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 暂停拉取新任务, 已经拉取的任务会继续执行
+func (w *WorkerWithFunc) Pause() {
+	atomic.StoreInt32(&w.paused, 1)
+}
+
+// 恢复拉取任务
+func (w *WorkerWithFunc) Resume() {
+	atomic.StoreInt32(&w.paused, 0)
+}
+
+func (w *WorkerWithFunc) Paused() bool {
+	return atomic.LoadInt32(&w.paused) == 1
+}
+
+// 暂停拉取新任务, 并等待已经拉取的任务执行完成, 超时返回ErrTimeout
+// 完成后worker保持暂停状态, 可以调用Resume恢复
+// @param timeout 如果小于等于0则默认10秒
+func (w *WorkerWithFunc) Drain(timeout time.Duration) error {
+	w.Pause()
+	if timeout <= 0 {
+		timeout = time.Second * 10
+	}
+
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt32(&w.pulling) == 1 || atomic.LoadInt64(&w.inflight) > 0 {
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(w.Job().timer)
+	}
+	return nil
+}
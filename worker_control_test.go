@@ -0,0 +1,73 @@
+package job
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	var done int64
+	j.AddFunc(q, "p", func(ctx context.Context, task *Task) { atomic.AddInt64(&done, 1) }, 1)
+	j.Start()
+	defer stopJob(t, j)
+
+	if err := j.Pause("p"); err != nil {
+		t.Fatal(err)
+	}
+	// 等待暂停前已经开始的拉取结束
+	time.Sleep(50 * time.Millisecond)
+	j.BatchEnqueue(context.Background(), "p", []string{"a", "b", "c"})
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&done); n != 0 {
+		t.Fatalf("executed %d tasks while paused", n)
+	}
+	if stats, _ := j.TopicStats("p"); stats["paused"] != 1 {
+		t.Fatal(stats)
+	}
+
+	if err := j.Resume("p"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt64(&done) == 3 })
+	if err := j.Pause("none"); err != ErrWorkerNotExist {
+		t.Fatal(err)
+	}
+}
+
+// Drain等待已拉取的任务执行完, 之后保持暂停
+func TestDrain(t *testing.T) {
+	q := newMemQueue()
+	j := New()
+	var done int64
+	release := make(chan struct{})
+	j.AddFunc(q, "d", func(ctx context.Context, task *Task) {
+		<-release
+		atomic.AddInt64(&done, 1)
+	}, 2)
+	j.BatchEnqueue(context.Background(), "d", []string{"a", "b"})
+	j.Start()
+	defer stopJob(t, j)
+
+	w, _ := j.getWorker("d")
+	waitFor(t, 2*time.Second, func() bool { return w.Running() == 2 })
+	if err := j.Drain("d", 50*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("drain with running tasks: %v", err)
+	}
+	close(release)
+	if err := j.Drain("d", 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&done); n != 2 {
+		t.Fatalf("done %d", n)
+	}
+
+	j.Enqueue(context.Background(), "d", "c")
+	time.Sleep(100 * time.Millisecond)
+	if q.len("d") != 1 {
+		t.Fatal("pulled a task after drain")
+	}
+}